@@ -0,0 +1,305 @@
+package billing
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+)
+
+// Виды транзакций журнала
+const (
+	KindCharge     = "task_charge"
+	KindEarning    = "worker_earning"
+	KindRefund     = "refund"
+	KindAdjustment = "adjustment"
+)
+
+// Счета журнала. balance и held ведутся для каждого пользователя,
+// revenue и external — общие счета платформы.
+const (
+	AccountBalance  = "balance"  // доступные средства пользователя, зеркалируются в users.balance
+	AccountHeld     = "held"     // средства клиента, зарезервированные под незавершённые задачи
+	AccountRevenue  = "revenue"  // доход платформы
+	AccountExternal = "external" // внешний мир: пополнения и ручные корректировки
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrUnbalanced        = errors.New("ledger transaction does not balance")
+	ErrInvalidAmount     = errors.New("invalid amount")
+)
+
+// Entry — одна проводка транзакции. Положительная сумма зачисляется на счёт,
+// отрицательная списывается; сумма проводок транзакции всегда равна нулю.
+type Entry struct {
+	Account string
+	UserID  *int64
+	Amount  float64
+}
+
+// Start подключает журнал к жизненному циклу задач: при создании задачи
+// её стоимость резервируется на счёте клиента, при решении резерв
+// списывается и вознаграждение зачисляется исполнителю, а при неудаче,
+// истечении или отмене резерв возвращается клиенту — в той же
+// транзакции, что и смена статуса.
+func Start() {
+	tasks.SubscribeTx(func(tx *sql.Tx, ev tasks.Event) error {
+		switch {
+		case ev.From == "" && ev.To == models.StatusPending:
+			return charge(tx, ev.TaskID, ev.UserID)
+		case ev.To == models.StatusSolved:
+			return settle(tx, ev)
+		case tasks.IsTerminal(ev.To):
+			_, err := releaseHeld(tx, ev.TaskID, ev.UserID, refundMemo(ev.To), nil)
+			return err
+		}
+		return nil
+	})
+}
+
+// Adjust выполняет ручную корректировку баланса пользователя администратором.
+// Списание не может сделать баланс отрицательным.
+func Adjust(userID int64, amount float64, memo string, adminID int64) error {
+	amount = round(amount)
+	if amount == 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return ErrInvalidAmount
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var balance float64
+	if err := tx.QueryRow("SELECT balance FROM users WHERE id = ?", userID).Scan(&balance); err != nil {
+		return err
+	}
+	if balance+amount < 0 {
+		return ErrInsufficientFunds
+	}
+
+	err = post(tx, KindAdjustment, nil, memo, &adminID,
+		Entry{Account: AccountExternal, Amount: -amount},
+		Entry{Account: AccountBalance, UserID: &userID, Amount: amount},
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Held возвращает сумму, зарезервированную под незавершённые задачи пользователя
+func Held(userID int64) (float64, error) {
+	var held float64
+	err := config.DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE account = ? AND user_id = ?",
+		AccountHeld, userID).Scan(&held)
+	return round(held), err
+}
+
+// ListForUser возвращает последние проводки по счетам пользователя
+func ListForUser(userID int64, limit int) ([]*models.LedgerEntry, error) {
+	rows, err := config.DB.Query(`
+		SELECT e.id, e.transaction_id, t.kind, e.account, e.user_id, t.task_id, e.amount, t.memo, t.created_at
+		FROM ledger_entries e
+		JOIN ledger_transactions t ON t.id = e.transaction_id
+		WHERE e.user_id = ?
+		ORDER BY e.id DESC
+		LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*models.LedgerEntry
+	for rows.Next() {
+		var e models.LedgerEntry
+		if err := rows.Scan(&e.ID, &e.TransactionID, &e.Kind, &e.Account, &e.UserID, &e.TaskID, &e.Amount, &e.Memo, &e.CreatedAt); err != nil {
+			continue
+		}
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
+
+// Reconcile сверяет users.balance с журналом, который считается источником
+// истины. Балансы, заведённые до появления журнала, переносятся в него
+// проводкой «opening balance»; прочие расхождения исправляются и логируются.
+func Reconcile() error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT u.id, u.balance,
+		       (SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE account = ? AND user_id = u.id),
+		       (SELECT COUNT(*) FROM ledger_entries WHERE user_id = u.id)
+		FROM users u`, AccountBalance)
+	if err != nil {
+		return err
+	}
+
+	type account struct {
+		userID         int64
+		cached, ledger float64
+		hasEntries     bool
+	}
+	var accounts []account
+	for rows.Next() {
+		var (
+			a       account
+			entries int
+		)
+		if err := rows.Scan(&a.userID, &a.cached, &a.ledger, &entries); err != nil {
+			rows.Close()
+			return err
+		}
+		a.hasEntries = entries > 0
+		accounts = append(accounts, a)
+	}
+	rows.Close()
+
+	for _, a := range accounts {
+		drift := round(a.cached - a.ledger)
+		if drift == 0 {
+			continue
+		}
+		userID := a.userID
+		if !a.hasEntries {
+			// post обновляет users.balance, поэтому сначала обнуляем кэш
+			if _, err := tx.Exec("UPDATE users SET balance = 0 WHERE id = ?", userID); err != nil {
+				return err
+			}
+			err := post(tx, KindAdjustment, nil, "opening balance", nil,
+				Entry{Account: AccountExternal, Amount: -drift},
+				Entry{Account: AccountBalance, UserID: &userID, Amount: drift},
+			)
+			if err != nil {
+				return err
+			}
+			log.Printf("💰 Opening balance %.6f recorded for user %d", drift, userID)
+			continue
+		}
+		if _, err := tx.Exec("UPDATE users SET balance = ? WHERE id = ?", a.ledger, userID); err != nil {
+			return err
+		}
+		log.Printf("⚠️ Balance of user %d drifted by %.6f from the ledger, reset to %.6f", userID, drift, a.ledger)
+	}
+	return tx.Commit()
+}
+
+// charge резервирует цену новой задачи (см. snapshot) на счёте клиента.
+// Администраторы могут уходить в минус, остальным не хватит средств — задача не создаётся.
+func charge(tx *sql.Tx, taskID, userID int64) error {
+	price, err := snapshot(tx, taskID)
+	if err != nil {
+		return err
+	}
+	if price <= 0 {
+		return nil
+	}
+
+	var (
+		balance float64
+		role    string
+	)
+	if err := tx.QueryRow("SELECT balance, role FROM users WHERE id = ?", userID).Scan(&balance, &role); err != nil {
+		return err
+	}
+	if role != "admin" && balance < price {
+		return fmt.Errorf("%w: balance %.6f, price %.6f", ErrInsufficientFunds, balance, price)
+	}
+
+	return post(tx, KindCharge, &taskID, "", nil,
+		Entry{Account: AccountBalance, UserID: &userID, Amount: -price},
+		Entry{Account: AccountHeld, UserID: &userID, Amount: price},
+	)
+}
+
+// settle списывает резерв решённой задачи: зафиксированное вознаграждение
+// получает исполнитель, остаток — платформа
+func settle(tx *sql.Tx, ev tasks.Event) error {
+	held, err := heldForTask(tx, ev.TaskID)
+	if err != nil {
+		return err
+	}
+	if held <= 0 || ev.SolverID == nil {
+		return nil
+	}
+
+	payout, err := payoutFor(tx, ev.TaskID)
+	if err != nil {
+		return err
+	}
+	payout = math.Min(payout, held)
+	userID := ev.UserID
+	return post(tx, KindEarning, &ev.TaskID, "", nil,
+		Entry{Account: AccountHeld, UserID: &userID, Amount: -held},
+		Entry{Account: AccountBalance, UserID: ev.SolverID, Amount: payout},
+		Entry{Account: AccountRevenue, Amount: round(held - payout)},
+	)
+}
+
+// heldForTask возвращает сумму, всё ещё зарезервированную под задачу
+func heldForTask(tx *sql.Tx, taskID int64) (float64, error) {
+	var held float64
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(e.amount), 0)
+		FROM ledger_entries e
+		JOIN ledger_transactions t ON t.id = e.transaction_id
+		WHERE t.task_id = ? AND e.account = ?`, taskID, AccountHeld).Scan(&held)
+	return round(held), err
+}
+
+// post записывает сбалансированную транзакцию и обновляет users.balance
+// для проводок по счёту balance
+func post(tx *sql.Tx, kind string, taskID *int64, memo string, createdBy *int64, entries ...Entry) error {
+	var sum float64
+	for i := range entries {
+		entries[i].Amount = round(entries[i].Amount)
+		sum += entries[i].Amount
+	}
+	if round(sum) != 0 {
+		return fmt.Errorf("%w: %s sums to %.6f", ErrUnbalanced, kind, sum)
+	}
+
+	var memoValue *string
+	if memo != "" {
+		memoValue = &memo
+	}
+	res, err := tx.Exec("INSERT INTO ledger_transactions (kind, task_id, memo, created_by) VALUES (?, ?, ?, ?)",
+		kind, taskID, memoValue, createdBy)
+	if err != nil {
+		return err
+	}
+	txID, _ := res.LastInsertId()
+
+	for _, e := range entries {
+		if e.Amount == 0 {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO ledger_entries (transaction_id, account, user_id, amount) VALUES (?, ?, ?, ?)",
+			txID, e.Account, e.UserID, e.Amount); err != nil {
+			return err
+		}
+		if e.Account == AccountBalance {
+			if _, err := tx.Exec("UPDATE users SET balance = round(balance + ?, 6) WHERE id = ?", e.Amount, *e.UserID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// round округляет сумму до миллионных, чтобы погрешность float не копилась в балансах
+func round(amount float64) float64 {
+	return math.Round(amount*1e6) / 1e6
+}
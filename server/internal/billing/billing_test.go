@@ -0,0 +1,162 @@
+package billing
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/db"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var startOnce sync.Once
+
+func setupDB(t *testing.T) {
+	t.Helper()
+	config.DBPath = filepath.Join(t.TempDir(), "test.db")
+	db.DB_Connect()
+	t.Cleanup(func() { config.DB.Close() })
+	startOnce.Do(Start)
+}
+
+func createUser(t *testing.T, username, role string, balance float64) int64 {
+	t.Helper()
+	res, err := config.DB.Exec("INSERT INTO users (username, password_hash, role, balance) VALUES (?, '', ?, ?)", username, role, balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func balanceOf(t *testing.T, userID int64) float64 {
+	t.Helper()
+	var balance float64
+	if err := config.DB.QueryRow("SELECT balance FROM users WHERE id = ?", userID).Scan(&balance); err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+func newTask(userID int64) *models.CaptchaTask {
+	return &models.CaptchaTask{UserID: userID, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+}
+
+func TestSolveMovesFundsFromClientToSolver(t *testing.T) {
+	setupDB(t)
+	if err := Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	client := createUser(t, "client", "client", 0)
+	worker := createUser(t, "worker", "worker", 0)
+	if err := Adjust(client, 1, "top up", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	task := newTask(client)
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := balanceOf(t, client), round(1-config.TaskPrice); got != want {
+		t.Fatalf("client balance after submit = %v, want %v", got, want)
+	}
+	if held, _ := Held(client); held != round(config.TaskPrice) {
+		t.Fatalf("held = %v, want %v", held, config.TaskPrice)
+	}
+
+	if err := tasks.Assign(task.ID, worker); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Solve(task.ID, worker, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if held, _ := Held(client); held != 0 {
+		t.Fatalf("held after solve = %v, want 0", held)
+	}
+	if got := balanceOf(t, worker); got != round(config.WorkerPayout) {
+		t.Fatalf("worker balance = %v, want %v", got, config.WorkerPayout)
+	}
+
+	var unbalanced int
+	err := config.DB.QueryRow(`SELECT COUNT(*) FROM (
+		SELECT transaction_id FROM ledger_entries GROUP BY transaction_id HAVING round(SUM(amount), 6) != 0
+	)`).Scan(&unbalanced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unbalanced != 0 {
+		t.Fatalf("%d ledger transactions do not sum to zero", unbalanced)
+	}
+}
+
+func TestSubmitRejectedWithoutFunds(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client", 0)
+
+	err := tasks.Create(newTask(client))
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("Create() error = %v, want ErrInsufficientFunds", err)
+	}
+
+	var count int
+	config.DB.QueryRow("SELECT COUNT(*) FROM tasks").Scan(&count)
+	if count != 0 {
+		t.Fatalf("task was stored despite insufficient funds")
+	}
+}
+
+func TestBatchHoldsFundsPerTask(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client", round(2*config.TaskPrice))
+
+	batch := []*models.CaptchaTask{newTask(client), newTask(client), newTask(client)}
+	errs, err := tasks.CreateBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs[0] != nil || errs[1] != nil || !errors.Is(errs[2], ErrInsufficientFunds) {
+		t.Fatalf("CreateBatch() errors = %v, want only the third task rejected for insufficient funds", errs)
+	}
+	if batch[0].ID == 0 || batch[1].ID == 0 {
+		t.Fatalf("created tasks have no IDs: %d, %d", batch[0].ID, batch[1].ID)
+	}
+
+	var count int
+	config.DB.QueryRow("SELECT COUNT(*) FROM tasks").Scan(&count)
+	if count != 2 {
+		t.Fatalf("%d tasks stored, want 2", count)
+	}
+	if held, _ := Held(client); held != round(2*config.TaskPrice) {
+		t.Fatalf("held = %v, want %v", held, round(2*config.TaskPrice))
+	}
+	if got := balanceOf(t, client); got != 0 {
+		t.Fatalf("client balance = %v, want 0", got)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	setupDB(t)
+	legacy := createUser(t, "legacy", "client", 5)
+	drifted := createUser(t, "drifted", "client", 0)
+	if err := Adjust(drifted, 2, "top up", 1); err != nil {
+		t.Fatal(err)
+	}
+	config.DB.Exec("UPDATE users SET balance = 7 WHERE id = ?", drifted)
+
+	if err := Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if got := balanceOf(t, legacy); got != 5 {
+		t.Fatalf("legacy balance = %v, want 5 carried into the ledger", got)
+	}
+	if got := balanceOf(t, drifted); got != 2 {
+		t.Fatalf("drifted balance = %v, want 2 from the ledger", got)
+	}
+
+	entries, err := ListForUser(legacy, 10)
+	if err != nil || len(entries) != 1 || entries[0].Amount != 5 {
+		t.Fatalf("legacy ledger = %+v, %v; want one opening entry of 5", entries, err)
+	}
+}
@@ -0,0 +1,220 @@
+package billing
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"database/sql"
+	"errors"
+	"math"
+)
+
+var (
+	ErrPriceNotFound = errors.New("price not found")
+	ErrInvalidPrice  = errors.New("invalid price")
+)
+
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// TypeStats — сводка по типу капчи для панели администратора
+type TypeStats struct {
+	CaptchaType string
+	Current     *models.Price
+	Solved      int
+	Revenue     float64 // сумма цен решённых задач
+	Payouts     float64 // сумма вознаграждений исполнителям
+}
+
+// Margin возвращает заработок платформы на решённых задачах
+func (s *TypeStats) Margin() float64 {
+	return round(s.Revenue - s.Payouts)
+}
+
+// MarginPercent возвращает маржу в процентах от выручки
+func (s *TypeStats) MarginPercent() float64 {
+	if s.Revenue == 0 {
+		return 0
+	}
+	return math.Round(s.Margin()/s.Revenue*1000) / 10
+}
+
+// Quote возвращает цену, действующую сейчас для типа капчи. Если в
+// прайс-листе нет записи для типа, используются config.TaskPrice и
+// config.WorkerPayout (такая цена имеет ID 0).
+func Quote(captchaType string) (*models.Price, error) {
+	return quote(config.DB, captchaType)
+}
+
+func quote(q querier, captchaType string) (*models.Price, error) {
+	var p models.Price
+	err := q.QueryRow(`
+		SELECT id, captcha_type, client_price, worker_payout, effective_from, created_by, created_at
+		FROM prices
+		WHERE captcha_type = ? AND effective_from <= datetime('now')
+		ORDER BY effective_from DESC, id DESC
+		LIMIT 1`, captchaType).
+		Scan(&p.ID, &p.CaptchaType, &p.ClientPrice, &p.WorkerPayout, &p.EffectiveFrom, &p.CreatedBy, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return &models.Price{
+			CaptchaType:  captchaType,
+			ClientPrice:  round(config.TaskPrice),
+			WorkerPayout: round(config.WorkerPayout),
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// PriceTask фиксирует в новой задаче действующие цену и вознаграждение
+// для её типа, а также приоритет по тарифу клиента; tasks.Create сохранит
+// их вместе с задачей
+func PriceTask(task *models.CaptchaTask) error {
+	p, err := Quote(task.CaptchaType)
+	if err != nil {
+		return err
+	}
+	tier, err := tierOf(task.UserID)
+	if err != nil {
+		return err
+	}
+	task.Price, task.Payout = &p.ClientPrice, &p.WorkerPayout
+	task.Priority = TierPriority(tier)
+	return nil
+}
+
+// ListPrices возвращает весь прайс-лист, включая прошлые и запланированные цены
+func ListPrices() ([]*models.Price, error) {
+	rows, err := config.DB.Query(`
+		SELECT id, captcha_type, client_price, worker_payout, effective_from, created_by, created_at
+		FROM prices
+		ORDER BY captcha_type, effective_from DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prices []*models.Price
+	for rows.Next() {
+		var p models.Price
+		if err := rows.Scan(&p.ID, &p.CaptchaType, &p.ClientPrice, &p.WorkerPayout, &p.EffectiveFrom, &p.CreatedBy, &p.CreatedAt); err != nil {
+			continue
+		}
+		prices = append(prices, &p)
+	}
+	return prices, nil
+}
+
+// AddPrice добавляет цену в прайс-лист. EffectiveFrom в формате
+// "2006-01-02 15:04:05" (UTC); пустое значение означает «с этого момента».
+// Вознаграждение исполнителю не может превышать цену для клиента.
+func AddPrice(p *models.Price) error {
+	p.ClientPrice = round(p.ClientPrice)
+	p.WorkerPayout = round(p.WorkerPayout)
+	if p.CaptchaType == "" || p.ClientPrice < 0 || p.WorkerPayout < 0 || p.WorkerPayout > p.ClientPrice {
+		return ErrInvalidPrice
+	}
+
+	var effectiveFrom interface{}
+	if p.EffectiveFrom != "" {
+		effectiveFrom = p.EffectiveFrom
+	}
+	res, err := config.DB.Exec(`INSERT INTO prices (captcha_type, client_price, worker_payout, effective_from, created_by)
+		VALUES (?, ?, ?, COALESCE(?, datetime('now')), ?)`,
+		p.CaptchaType, p.ClientPrice, p.WorkerPayout, effectiveFrom, p.CreatedBy)
+	if err != nil {
+		return err
+	}
+	p.ID, _ = res.LastInsertId()
+	return nil
+}
+
+// DeletePrice удаляет ещё не вступившую в силу цену. Действовавшие цены
+// остаются в истории, поскольку на них ссылаются снимки в задачах.
+func DeletePrice(id int64) error {
+	res, err := config.DB.Exec("DELETE FROM prices WHERE id = ? AND effective_from > datetime('now')", id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrPriceNotFound
+	}
+	return nil
+}
+
+// StatsByType возвращает действующие цены и маржу по решённым задачам
+// для каждого типа капчи, встречающегося в прайс-листе или в задачах
+func StatsByType() ([]*TypeStats, error) {
+	rows, err := config.DB.Query(`
+		SELECT t.captcha_type,
+		       COUNT(s.id),
+		       COALESCE(SUM(s.price), 0),
+		       COALESCE(SUM(s.payout), 0)
+		FROM (
+			SELECT captcha_type FROM prices
+			UNION
+			SELECT captcha_type FROM tasks
+		) t
+		LEFT JOIN tasks s ON s.captcha_type = t.captcha_type AND s.status = ?
+		GROUP BY t.captcha_type
+		ORDER BY t.captcha_type`, models.StatusSolved)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []*TypeStats
+	for rows.Next() {
+		var s TypeStats
+		if err := rows.Scan(&s.CaptchaType, &s.Solved, &s.Revenue, &s.Payouts); err != nil {
+			continue
+		}
+		s.Revenue, s.Payouts = round(s.Revenue), round(s.Payouts)
+		stats = append(stats, &s)
+	}
+	rows.Close()
+
+	for _, s := range stats {
+		if s.Current, err = Quote(s.CaptchaType); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// snapshot возвращает цену задачи, зафиксированную при создании. Если
+// создатель задачи её не указал, фиксирует действующую цену прайс-листа.
+func snapshot(tx *sql.Tx, taskID int64) (float64, error) {
+	var (
+		captchaType string
+		price       sql.NullFloat64
+	)
+	if err := tx.QueryRow("SELECT captcha_type, price FROM tasks WHERE id = ?", taskID).Scan(&captchaType, &price); err != nil {
+		return 0, err
+	}
+	if price.Valid {
+		return round(price.Float64), nil
+	}
+
+	p, err := quote(tx, captchaType)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE tasks SET price = ?, payout = ? WHERE id = ?", p.ClientPrice, p.WorkerPayout, taskID); err != nil {
+		return 0, err
+	}
+	return p.ClientPrice, nil
+}
+
+// payoutFor возвращает вознаграждение исполнителю, зафиксированное в задаче
+func payoutFor(tx *sql.Tx, taskID int64) (float64, error) {
+	var payout sql.NullFloat64
+	if err := tx.QueryRow("SELECT payout FROM tasks WHERE id = ?", taskID).Scan(&payout); err != nil {
+		return 0, err
+	}
+	if !payout.Valid {
+		return round(config.WorkerPayout), nil
+	}
+	return round(payout.Float64), nil
+}
@@ -0,0 +1,88 @@
+package billing
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"testing"
+)
+
+func TestQuoteUsesLatestEffectivePrice(t *testing.T) {
+	setupDB(t)
+
+	for _, p := range []*models.Price{
+		{CaptchaType: "recaptcha", ClientPrice: 0.002, WorkerPayout: 0.001, EffectiveFrom: "2020-01-01 00:00:00"},
+		{CaptchaType: "recaptcha", ClientPrice: 0.004, WorkerPayout: 0.003, EffectiveFrom: "2021-01-01 00:00:00"},
+		{CaptchaType: "recaptcha", ClientPrice: 0.009, WorkerPayout: 0.005, EffectiveFrom: "2999-01-01 00:00:00"},
+	} {
+		if err := AddPrice(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := Quote("recaptcha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ClientPrice != 0.004 || p.WorkerPayout != 0.003 {
+		t.Fatalf("Quote() = %+v, want the 2021 price", p)
+	}
+
+	p, err = Quote("hcaptcha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != 0 || p.ClientPrice != round(config.TaskPrice) {
+		t.Fatalf("Quote() for an unpriced type = %+v, want config defaults", p)
+	}
+
+	if err := AddPrice(&models.Price{CaptchaType: "recaptcha", ClientPrice: 0.001, WorkerPayout: 0.002}); err != ErrInvalidPrice {
+		t.Fatalf("AddPrice() with payout above price: err = %v, want ErrInvalidPrice", err)
+	}
+}
+
+func TestTaskKeepsPriceSnapshot(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client", 0)
+	worker := createUser(t, "worker", "worker", 0)
+	if err := Adjust(client, 1, "top up", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddPrice(&models.Price{CaptchaType: "hcaptcha", ClientPrice: 0.01, WorkerPayout: 0.006}); err != nil {
+		t.Fatal(err)
+	}
+
+	task := newTask(client)
+	if err := PriceTask(task); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+
+	// Новая цена не должна влиять на уже созданную задачу
+	if err := AddPrice(&models.Price{CaptchaType: "hcaptcha", ClientPrice: 0.5, WorkerPayout: 0.4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Assign(task.ID, worker); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Solve(task.ID, worker, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := balanceOf(t, client); got != 0.99 {
+		t.Fatalf("client balance = %v, want 0.99", got)
+	}
+	if got := balanceOf(t, worker); got != 0.006 {
+		t.Fatalf("worker balance = %v, want 0.006", got)
+	}
+
+	stats, err := StatsByType()
+	if err != nil || len(stats) != 1 {
+		t.Fatalf("StatsByType() = %v, %v", stats, err)
+	}
+	if s := stats[0]; s.Solved != 1 || s.Margin() != 0.004 || s.Current.ClientPrice != 0.5 {
+		t.Fatalf("stats = %+v, want one solved task with margin 0.004 and current price 0.5", s)
+	}
+}
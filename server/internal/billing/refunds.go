@@ -0,0 +1,171 @@
+package billing
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrNothingToRefund = errors.New("nothing to refund")
+	ErrTaskNotFinished = errors.New("task is not finished yet")
+)
+
+// Refund возвращает клиенту деньги за завершённую задачу по решению
+// администратора. За решённую задачу возвращается её цена за счёт дохода
+// платформы (вознаграждение исполнителя не отзывается); остаток резерва,
+// если он есть, снимается. Повторный возврат по той же задаче возвращает
+// ErrNothingToRefund.
+func Refund(taskID int64, memo string, adminID int64) (float64, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var (
+		userID int64
+		status string
+	)
+	err = tx.QueryRow("SELECT user_id, status FROM tasks WHERE id = ?", taskID).Scan(&userID, &status)
+	if err == sql.ErrNoRows {
+		return 0, tasks.ErrTaskNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !tasks.IsTerminal(status) {
+		return 0, ErrTaskNotFinished
+	}
+
+	amount, err := RefundTx(tx, taskID, userID, memo, &adminID)
+	if err != nil {
+		return 0, err
+	}
+	return amount, tx.Commit()
+}
+
+// RefundTx возвращает клиенту userID деньги за задачу в рамках транзакции
+// вызывающего: сначала остаток резерва, иначе списанную цену за вычетом
+// прежних возвратов. Если возвращать нечего, возвращает ErrNothingToRefund.
+func RefundTx(tx *sql.Tx, taskID, userID int64, memo string, createdBy *int64) (float64, error) {
+	amount, err := releaseHeld(tx, taskID, userID, memo, createdBy)
+	if err != nil {
+		return 0, err
+	}
+	if amount == 0 {
+		if amount, err = refundCharged(tx, taskID, userID, memo, createdBy); err != nil {
+			return 0, err
+		}
+	}
+	if amount == 0 {
+		return 0, ErrNothingToRefund
+	}
+	return amount, nil
+}
+
+// RefundsByTask возвращает суммы возвратов клиенту по его задачам
+func RefundsByTask(userID int64) (map[int64]float64, error) {
+	return refundsWhere("AND e.user_id = ?", userID)
+}
+
+// RefundedFor возвращает сумму, фактически возвращённую клиенту по задаче
+func RefundedFor(taskID int64) (float64, error) {
+	refunds, err := refundsWhere("AND t.task_id = ?", taskID)
+	if err != nil {
+		return 0, err
+	}
+	return refunds[taskID], nil
+}
+
+// AllRefundsByTask возвращает суммы возвратов по всем задачам
+func AllRefundsByTask() (map[int64]float64, error) {
+	return refundsWhere("")
+}
+
+func refundsWhere(filter string, args ...interface{}) (map[int64]float64, error) {
+	rows, err := config.DB.Query(`
+		SELECT t.task_id, SUM(e.amount)
+		FROM ledger_transactions t
+		JOIN ledger_entries e ON e.transaction_id = t.id AND e.account = ?
+		WHERE t.kind = ? AND t.task_id IS NOT NULL `+filter+`
+		GROUP BY t.task_id`, append([]interface{}{AccountBalance, KindRefund}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := make(map[int64]float64)
+	for rows.Next() {
+		var (
+			taskID int64
+			amount float64
+		)
+		if err := rows.Scan(&taskID, &amount); err != nil {
+			continue
+		}
+		refunds[taskID] = round(amount)
+	}
+	return refunds, nil
+}
+
+// refundMemo — пояснение к автоматическому возврату
+func refundMemo(status string) string {
+	switch status {
+	case models.StatusFailed:
+		return "task failed"
+	case models.StatusExpired:
+		return "task expired"
+	case models.StatusCancelled:
+		return "task cancelled"
+	}
+	return "task " + status
+}
+
+// releaseHeld возвращает на баланс клиента всё, что ещё зарезервировано под
+// задачу. Повторный вызов ничего не делает: резерв уже равен нулю.
+func releaseHeld(tx *sql.Tx, taskID, userID int64, memo string, createdBy *int64) (float64, error) {
+	held, err := heldForTask(tx, taskID)
+	if err != nil || held <= 0 {
+		return 0, err
+	}
+	err = post(tx, KindRefund, &taskID, memo, createdBy,
+		Entry{Account: AccountHeld, UserID: &userID, Amount: -held},
+		Entry{Account: AccountBalance, UserID: &userID, Amount: held},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return held, nil
+}
+
+// refundCharged возвращает клиенту списанную за решённую задачу сумму,
+// за вычетом уже сделанных возвратов
+func refundCharged(tx *sql.Tx, taskID, userID int64, memo string, createdBy *int64) (float64, error) {
+	var charged, refunded float64
+	err := tx.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN t.kind = ? THEN -e.amount END), 0),
+			COALESCE(SUM(CASE WHEN t.kind = ? THEN e.amount END), 0)
+		FROM ledger_transactions t
+		JOIN ledger_entries e ON e.transaction_id = t.id AND e.account = ? AND e.user_id = ?
+		WHERE t.task_id = ?`, KindCharge, KindRefund, AccountBalance, userID, taskID).Scan(&charged, &refunded)
+	if err != nil {
+		return 0, err
+	}
+
+	amount := round(charged - refunded)
+	if amount <= 0 {
+		return 0, nil
+	}
+	err = post(tx, KindRefund, &taskID, memo, createdBy,
+		Entry{Account: AccountRevenue, Amount: -amount},
+		Entry{Account: AccountBalance, UserID: &userID, Amount: amount},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return amount, nil
+}
@@ -0,0 +1,79 @@
+package billing
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/tasks"
+	"errors"
+	"testing"
+)
+
+func TestFailedTaskIsRefundedOnce(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client", 0)
+	if err := Adjust(client, 1, "top up", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	task := newTask(client)
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Fail(task.ID, "unsolvable"); err != nil {
+		t.Fatal(err)
+	}
+	if got := balanceOf(t, client); got != 1 {
+		t.Fatalf("client balance after failure = %v, want 1", got)
+	}
+	if held, _ := Held(client); held != 0 {
+		t.Fatalf("held after failure = %v, want 0", held)
+	}
+
+	// Ручной возврат по уже возмещённой задаче ничего не добавляет
+	if _, err := Refund(task.ID, "dispute", 1); !errors.Is(err, ErrNothingToRefund) {
+		t.Fatalf("Refund() error = %v, want ErrNothingToRefund", err)
+	}
+	refunds, err := RefundsByTask(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunds[task.ID] != round(config.TaskPrice) {
+		t.Fatalf("refunds = %v, want %v for task #%d", refunds, config.TaskPrice, task.ID)
+	}
+}
+
+func TestManualRefundOfSolvedTask(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client", 0)
+	worker := createUser(t, "worker", "worker", 0)
+	if err := Adjust(client, 1, "top up", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	task := newTask(client)
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Refund(task.ID, "dispute", 1); !errors.Is(err, ErrTaskNotFinished) {
+		t.Fatalf("Refund() of a pending task: err = %v, want ErrTaskNotFinished", err)
+	}
+	if err := tasks.Assign(task.ID, worker); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Solve(task.ID, worker, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	amount, err := Refund(task.ID, "dispute", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amount != round(config.TaskPrice) || balanceOf(t, client) != 1 {
+		t.Fatalf("refunded %v, client balance %v; want full price back", amount, balanceOf(t, client))
+	}
+	if got := balanceOf(t, worker); got != round(config.WorkerPayout) {
+		t.Fatalf("worker balance = %v, want payout kept", got)
+	}
+	if _, err := Refund(task.ID, "dispute", 1); !errors.Is(err, ErrNothingToRefund) {
+		t.Fatalf("second Refund() error = %v, want ErrNothingToRefund", err)
+	}
+}
@@ -0,0 +1,89 @@
+package billing
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"database/sql"
+	"errors"
+)
+
+// Тарифы клиентов (колонка users.tier)
+const (
+	TierEconomy  = "economy"
+	TierStandard = "standard"
+	TierPremium  = "premium"
+)
+
+var (
+	ErrInvalidTier     = errors.New("invalid tier")
+	ErrInvalidPriority = errors.New("invalid priority")
+)
+
+// Tiers — тарифы в порядке возрастания и приоритет, который получают задачи клиента
+var Tiers = []struct {
+	Name     string
+	Priority int
+}{
+	{TierEconomy, tasks.PriorityLow},
+	{TierStandard, tasks.PriorityNormal},
+	{TierPremium, tasks.PriorityHigh},
+}
+
+// TierPriority возвращает приоритет задач клиента с тарифом tier;
+// для неизвестного тарифа — как для standard
+func TierPriority(tier string) int {
+	for _, t := range Tiers {
+		if t.Name == tier {
+			return t.Priority
+		}
+	}
+	return tasks.PriorityNormal
+}
+
+// SetTier меняет тариф клиента. Уже созданные задачи сохраняют свой приоритет.
+func SetTier(userID int64, tier string) error {
+	valid := false
+	for _, t := range Tiers {
+		valid = valid || t.Name == tier
+	}
+	if !valid {
+		return ErrInvalidTier
+	}
+
+	res, err := config.DB.Exec("UPDATE users SET tier = ? WHERE id = ?", tier, userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Bid поднимает приоритет новой задачи до level с доплатой
+// config.PrioritySurcharge за каждый уровень выше тарифа клиента.
+// Вызывается после PriceTask; ставка не выше тарифа ничего не меняет.
+func Bid(task *models.CaptchaTask, level int) error {
+	if level < tasks.PriorityLow || level > tasks.PriorityHigh {
+		return ErrInvalidPriority
+	}
+	if level <= task.Priority {
+		return nil
+	}
+
+	price := round(task.PriceValue() + float64(level-task.Priority)*config.PrioritySurcharge)
+	task.Price = &price
+	task.Priority = level
+	return nil
+}
+
+// tierOf возвращает тариф пользователя; для неизвестного — standard
+func tierOf(userID int64) (string, error) {
+	var tier string
+	err := config.DB.QueryRow("SELECT tier FROM users WHERE id = ?", userID).Scan(&tier)
+	if err == sql.ErrNoRows {
+		return TierStandard, nil
+	}
+	return tier, err
+}
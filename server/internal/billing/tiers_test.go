@@ -0,0 +1,47 @@
+package billing
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/tasks"
+	"errors"
+	"testing"
+)
+
+func TestTierAndBid(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client", 0)
+
+	task := newTask(client)
+	if err := PriceTask(task); err != nil {
+		t.Fatal(err)
+	}
+	if task.Priority != tasks.PriorityNormal {
+		t.Fatalf("standard tier priority = %d, want normal", task.Priority)
+	}
+	base := task.PriceValue()
+
+	if err := Bid(task, tasks.PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	if task.Priority != tasks.PriorityHigh || task.PriceValue() != round(base+config.PrioritySurcharge) {
+		t.Fatalf("after bid: priority %d, price %v; want high for %v", task.Priority, task.PriceValue(), round(base+config.PrioritySurcharge))
+	}
+
+	if err := SetTier(client, TierPremium); err != nil {
+		t.Fatal(err)
+	}
+	task = newTask(client)
+	if err := PriceTask(task); err != nil {
+		t.Fatal(err)
+	}
+	if err := Bid(task, tasks.PriorityLow); err != nil {
+		t.Fatal(err)
+	}
+	if task.Priority != tasks.PriorityHigh || task.PriceValue() != base {
+		t.Fatalf("premium task: priority %d, price %v; want high at base price %v", task.Priority, task.PriceValue(), base)
+	}
+
+	if err := SetTier(client, "gold"); !errors.Is(err, ErrInvalidTier) {
+		t.Fatalf("SetTier(gold) err = %v, want ErrInvalidTier", err)
+	}
+}
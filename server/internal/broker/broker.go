@@ -0,0 +1,166 @@
+package broker
+
+import (
+	"captcha-solver/internal/config"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotConnected = errors.New("broker is not connected")
+	// ErrMalformed — сообщение не удалось разобрать, повторная доставка не поможет
+	ErrMalformed = errors.New("malformed message")
+)
+
+// Broker — брокер сообщений, через который сервер передаёт задачи и события
+// о результатах. Реализации: RabbitMQ (пакет rabbitmq) и Memory — очереди
+// внутри процесса для запуска одним бинарником и для тестов.
+type Broker interface {
+	// Publish отправляет сообщения в обменник exchange ("" — прямо в очередь
+	// routingKey) и возвращает nil, только когда брокер принял все сообщения
+	Publish(ctx context.Context, exchange, routingKey string, msgs ...Message) error
+
+	// Consume передаёт сообщения очереди в handle по одному, пока брокер не
+	// закрыт. handle должен подтвердить или отклонить каждое сообщение.
+	Consume(queue string, handle func(d Delivery))
+
+	// Scan выбирает до limit сообщений очереди без подтверждения и вызывает
+	// для каждого visit, пока тот не вернёт stop; подтверждённое в visit
+	// сообщение удаляется, остальные остаются в очереди. Возвращает число
+	// сообщений в очереди на момент начала просмотра.
+	Scan(queue string, limit int, visit func(d Delivery) (stop bool, err error)) (int, error)
+
+	// Status возвращает состояние подключения (для /health)
+	Status() State
+
+	// Close останавливает потребителей и закрывает соединение
+	Close()
+}
+
+// Message — сообщение брокера; тело всегда JSON
+type Message struct {
+	ID      string
+	Body    []byte
+	Headers map[string]interface{}
+
+	// Expiration — срок жизни сообщения в очереди (0 — без срока). Истёкшее
+	// сообщение перекладывается по DeadLetter очереди или отбрасывается.
+	Expiration time.Duration
+}
+
+// Delivery — полученное сообщение, которое нужно подтвердить или отклонить
+type Delivery struct {
+	Message
+
+	ack  func() error
+	nack func(requeue bool) error
+}
+
+// NewDelivery используется реализациями Broker
+func NewDelivery(msg Message, ack func() error, nack func(requeue bool) error) Delivery {
+	return Delivery{Message: msg, ack: ack, nack: nack}
+}
+
+// Ack подтверждает обработку сообщения
+func (d Delivery) Ack() error {
+	return d.ack()
+}
+
+// Nack отклоняет сообщение: с requeue оно возвращается в очередь, без — удаляется
+func (d Delivery) Nack(requeue bool) error {
+	return d.nack(requeue)
+}
+
+// State — состояние подключения к брокеру
+type State struct {
+	Kind       string    `json:"kind"`
+	Connected  bool      `json:"connected"`
+	Since      time.Time `json:"since"` // когда подключение установлено или потеряно
+	LastError  string    `json:"last_error,omitempty"`
+	Reconnects int       `json:"reconnects"` // сколько раз соединение восстанавливалось
+}
+
+var (
+	mu          sync.Mutex
+	current     Broker
+	initialized bool
+)
+
+// Init задаёт брокер сервера, выбранный по config.BrokerKind. Вызывается
+// один раз при запуске, до старта потребителей; повторный вызов — ошибка
+// программы.
+func Init(b Broker) {
+	mu.Lock()
+	defer mu.Unlock()
+	if initialized {
+		panic("broker: Init called more than once")
+	}
+	current, initialized = b, true
+}
+
+// Default возвращает брокер сервера. Вызов до Init — ошибка программы:
+// молча подставленные очереди в памяти потеряли бы сообщения при перезапуске.
+func Default() Broker {
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		panic("broker: Default called before Init")
+	}
+	return current
+}
+
+// Очереди и обменники сервера, общие для всех реализаций
+const (
+	// Очередь недоставленных сообщений (DLQ): сюда попадают сообщения, которые
+	// потребитель не смог обработать, вместе с текстом ошибки в заголовках
+	DeadLetterExchange = config.QueueName + ".dlx"
+	DeadLetterQueue    = config.QueueName + ".dlq"
+
+	// Очередь повторов: сообщение ждёт в ней config.RabbitMQRetryDelay и
+	// затем возвращается брокером в очередь задач
+	RetryQueue = config.QueueName + ".retry"
+)
+
+// Queue — очередь сервера. Если задан DeadLetter, истёкшие сообщения брокер
+// публикует в обменник DeadLetter.Exchange с ключом DeadLetter.Key
+// (аргументы x-dead-letter-exchange и x-dead-letter-routing-key).
+type Queue struct {
+	Name       string
+	DeadLetter *Route
+}
+
+// Route — обменник ("" — очередь по имени) и ключ маршрутизации
+type Route struct {
+	Exchange string
+	Key      string
+}
+
+// Binding — привязка очереди к обменнику
+type Binding struct {
+	Exchange string
+	Kind     string // topic или fanout
+	Key      string
+	Queue    string
+}
+
+// Queues — очереди, которые объявляет сервер
+var Queues = []Queue{
+	{Name: config.QueueName},
+	{Name: DeadLetterQueue},
+	{Name: config.ResultsQueue},
+	{Name: RetryQueue, DeadLetter: &Route{Exchange: "", Key: config.QueueName}},
+}
+
+// Bindings — обменники сервера и привязанные к ним очереди. Внешние системы
+// привязывают к обменнику результатов свои очереди.
+var Bindings = []Binding{
+	{Exchange: DeadLetterExchange, Kind: "fanout", Queue: DeadLetterQueue},
+	{Exchange: config.ResultsExchange, Kind: "topic", Key: "task.#", Queue: config.ResultsQueue},
+}
+
+// withTimeout — контекст публикации для фоновых отправок
+func withTimeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
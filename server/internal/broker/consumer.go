@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// PublishTasks отправляет задачи в очередь задач одним пакетом
+func PublishTasks(ctx context.Context, batch ...*models.CaptchaTask) error {
+	msgs := make([]Message, 0, len(batch))
+	for _, task := range batch {
+		body, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, Message{Body: body})
+	}
+	return Default().Publish(ctx, "", config.QueueName, msgs...)
+}
+
+// ConsumeTasks читает задачи из очереди и записывает их в БД. Сообщение
+// подтверждается только после записи.
+func ConsumeTasks() {
+	Default().Consume(config.QueueName, handleTask)
+}
+
+// resultRetryDelay — пауза перед возвратом в очередь события, которое не
+// удалось обработать, чтобы не крутить его в цикле, пока недоступна БД
+const resultRetryDelay = time.Second
+
+// ConsumeResults читает события о результатах задач из очереди сервера и
+// передаёт тело каждого в handle. Сообщение подтверждается после успешного
+// handle; при ошибке оно возвращается в очередь, а сообщение, которое
+// handle не смог разобрать (ErrMalformed), отбрасывается.
+func ConsumeResults(handle func(body []byte) error) {
+	Default().Consume(config.ResultsQueue, func(d Delivery) {
+		if err := handle(d.Body); err != nil {
+			log.Println("Ошибка обработки события о результате:", err)
+			requeue := !errors.Is(err, ErrMalformed)
+			if requeue {
+				time.Sleep(resultRetryDelay)
+			}
+			if err := d.Nack(requeue); err != nil {
+				log.Println("Ошибка отклонения сообщения:", err)
+			}
+			return
+		}
+		if err := d.Ack(); err != nil {
+			log.Println("Ошибка подтверждения сообщения:", err)
+		}
+	})
+}
+
+// handleTask вставляет задачу из сообщения в БД и подтверждает сообщение.
+// Некорректное сообщение сразу уходит в DLQ, а при ошибке записи сообщение
+// повторяется до config.RabbitMQMaxRetries раз (retryOrDeadLetter).
+func handleTask(d Delivery) {
+	var task models.CaptchaTask
+	if err := json.Unmarshal(d.Body, &task); err != nil {
+		log.Println("Ошибка декодирования сообщения:", err)
+		// Повторная доставка не поможет
+		deadLetter(d, err)
+		return
+	}
+	// Вставляем задачу в БД. Уже существующую задачу не трогаем, чтобы
+	// не затереть её статус, который меняется только через пакет tasks.
+	_, err := config.DB.Exec(`INSERT OR IGNORE INTO tasks
+		(id, public_id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response, status, created_at)
+		VALUES (?, COALESCE(NULLIF(?, ''), 'tsk_' || lower(hex(randomblob(16)))), ?, ?, ?, ?, ?, ?, ?, COALESCE(NULLIF(?, ''), datetime('now')) )`,
+		task.ID, task.PublicID, task.UserID, task.SolverID, task.CaptchaType, task.SiteKey, task.TargetURL, task.CaptchaResponse, models.StatusPending, task.CreatedAt)
+	if err != nil {
+		log.Println("Ошибка вставки задачи в БД:", err)
+		retryOrDeadLetter(d, err)
+		return
+	}
+	if err := d.Ack(); err != nil {
+		log.Println("Ошибка подтверждения сообщения:", err)
+	}
+	log.Printf("Задача получена из брокера: %+v\n", task)
+}
@@ -0,0 +1,159 @@
+package broker
+
+import (
+	"captcha-solver/internal/config"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	headerRetries  = "x-retry-count"
+	headerError    = "x-error"
+	headerFailedAt = "x-failed-at"
+
+	// Сколько сообщений DLQ просматривается при поиске по ID
+	maxDeadLetterScan = 1000
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter — сообщение в DLQ
+type DeadLetter struct {
+	ID       string
+	Body     string
+	Error    string
+	Retries  int
+	FailedAt time.Time
+}
+
+// retryOrDeadLetter откладывает сообщение в очередь повторов со счётчиком
+// попыток в заголовке x-retry-count: через config.RabbitMQRetryDelay брокер
+// вернёт его в очередь задач, поэтому короткий сбой БД не исчерпывает
+// попытки. После config.RabbitMQMaxRetries попыток сообщение уходит в DLQ.
+func retryOrDeadLetter(d Delivery, cause error) {
+	retries := retryCount(d.Headers)
+	if retries >= config.RabbitMQMaxRetries {
+		deadLetter(d, cause)
+		return
+	}
+
+	retry := *copyMessage(d.Message)
+	retry.Headers[headerRetries] = int32(retries + 1)
+	retry.Headers[headerError] = cause.Error()
+	retry.Expiration = config.RabbitMQRetryDelay
+	settle(d, RetryQueue, publishOne("", RetryQueue, retry))
+}
+
+// deadLetter отправляет сообщение в DLQ с текстом ошибки и подтверждает оригинал
+func deadLetter(d Delivery, cause error) {
+	dead := *copyMessage(d.Message)
+	dead.Headers[headerError] = cause.Error()
+	dead.Headers[headerFailedAt] = time.Now().UTC()
+	if dead.ID == "" {
+		dead.ID = newMessageID()
+	}
+	settle(d, DeadLetterQueue, publishOne(DeadLetterExchange, config.QueueName, dead))
+}
+
+// settle подтверждает исходное сообщение, если его копия принята брокером;
+// иначе возвращает его в очередь, чтобы не потерять
+func settle(d Delivery, target string, err error) {
+	if err != nil {
+		log.Printf("Ошибка отправки сообщения в %s: %v", target, err)
+		if err := d.Nack(true); err != nil {
+			log.Println("Ошибка отклонения сообщения:", err)
+		}
+		return
+	}
+	if err := d.Ack(); err != nil {
+		log.Println("Ошибка подтверждения сообщения:", err)
+	}
+	log.Printf("↪️ Сообщение перенаправлено в %s", target)
+}
+
+func publishOne(exchange, routingKey string, msg Message) error {
+	ctx, cancel := withTimeout()
+	defer cancel()
+	return Default().Publish(ctx, exchange, routingKey, msg)
+}
+
+func retryCount(headers map[string]interface{}) int {
+	switch n := headers[headerRetries].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+func newMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// DeadLetters возвращает до limit первых сообщений DLQ и общее их число.
+// Сообщения остаются в очереди.
+func DeadLetters(limit int) ([]DeadLetter, int, error) {
+	var letters []DeadLetter
+	total, err := Default().Scan(DeadLetterQueue, limit, func(d Delivery) (bool, error) {
+		letters = append(letters, toDeadLetter(d.Message))
+		return false, nil
+	})
+	return letters, total, err
+}
+
+// ReplayDeadLetter возвращает сообщение из DLQ в очередь задач со
+// сброшенным счётчиком попыток
+func ReplayDeadLetter(id string) error {
+	return takeDeadLetter(id, func(msg Message) error {
+		replay := *copyMessage(msg)
+		delete(replay.Headers, headerRetries)
+		delete(replay.Headers, headerError)
+		delete(replay.Headers, headerFailedAt)
+		replay.Expiration = 0
+		return publishOne("", config.QueueName, replay)
+	})
+}
+
+// DiscardDeadLetter удаляет сообщение из DLQ
+func DiscardDeadLetter(id string) error {
+	return takeDeadLetter(id, func(Message) error { return nil })
+}
+
+// takeDeadLetter находит сообщение DLQ по ID, вызывает fn и при успехе
+// подтверждает (удаляет) сообщение
+func takeDeadLetter(id string, fn func(msg Message) error) error {
+	found := false
+	_, err := Default().Scan(DeadLetterQueue, maxDeadLetterScan, func(d Delivery) (bool, error) {
+		if d.ID != id {
+			return false, nil
+		}
+		found = true
+		if err := fn(d.Message); err != nil {
+			return true, err
+		}
+		return true, d.Ack()
+	})
+	if err == nil && !found {
+		err = ErrDeadLetterNotFound
+	}
+	return err
+}
+
+func toDeadLetter(msg Message) DeadLetter {
+	letter := DeadLetter{
+		ID:      msg.ID,
+		Body:    string(msg.Body),
+		Retries: retryCount(msg.Headers),
+	}
+	letter.Error, _ = msg.Headers[headerError].(string)
+	letter.FailedAt, _ = msg.Headers[headerFailedAt].(time.Time)
+	return letter
+}
@@ -0,0 +1,123 @@
+package broker
+
+import (
+	"captcha-solver/internal/config"
+	"testing"
+	"time"
+)
+
+func TestRetryCount(t *testing.T) {
+	for _, tc := range []struct {
+		headers map[string]interface{}
+		want    int
+	}{
+		{nil, 0},
+		{map[string]interface{}{headerRetries: int32(2)}, 2},
+		{map[string]interface{}{headerRetries: int64(3)}, 3},
+		{map[string]interface{}{headerRetries: "3"}, 0},
+	} {
+		if got := retryCount(tc.headers); got != tc.want {
+			t.Errorf("retryCount(%v) = %d, want %d", tc.headers, got, tc.want)
+		}
+	}
+}
+
+func TestCopyKeepsOriginalHeaders(t *testing.T) {
+	failedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := Message{
+		ID:      "m1",
+		Body:    []byte(`{"id":1}`),
+		Headers: map[string]interface{}{headerRetries: int32(1), headerError: "db locked", headerFailedAt: failedAt},
+	}
+
+	out := copyMessage(msg)
+	out.Headers[headerRetries] = int32(2)
+	if retryCount(msg.Headers) != 1 {
+		t.Fatal("copyMessage() shares headers with the original message")
+	}
+	if out.ID != "m1" || string(out.Body) != `{"id":1}` {
+		t.Fatalf("copyMessage() = %+v, want a copy of the message", out)
+	}
+
+	letter := toDeadLetter(msg)
+	if letter.ID != "m1" || letter.Error != "db locked" || letter.Retries != 1 || !letter.FailedAt.Equal(failedAt) {
+		t.Fatalf("toDeadLetter() = %+v", letter)
+	}
+}
+
+func TestDeadLetterReplayAndDiscard(t *testing.T) {
+	memory := NewMemory()
+	t.Cleanup(memory.Close)
+	use(t, memory)
+
+	// Некорректные сообщения сразу уходят в DLQ
+	deadLetter(newTestDelivery(Message{ID: "bad-1", Body: []byte("not json")}), errTest)
+	deadLetter(newTestDelivery(Message{ID: "bad-2", Body: []byte("not json")}), errTest)
+
+	letters, total, err := DeadLetters(10)
+	if err != nil || total != 2 || len(letters) != 2 || letters[0].Error != errTest.Error() || letters[0].FailedAt.IsZero() {
+		t.Fatalf("DeadLetters() = %+v, %d, %v; want two letters with the error", letters, total, err)
+	}
+
+	if err := ReplayDeadLetter("bad-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DiscardDeadLetter("bad-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DiscardDeadLetter("bad-2"); err != ErrDeadLetterNotFound {
+		t.Fatalf("second DiscardDeadLetter() = %v, want ErrDeadLetterNotFound", err)
+	}
+	if _, total, _ := DeadLetters(10); total != 0 {
+		t.Fatalf("%d dead letters left, want 0", total)
+	}
+
+	var replayed []Message
+	Default().Scan("captcha_tasks", 10, func(d Delivery) (bool, error) {
+		replayed = append(replayed, d.Message)
+		return false, nil
+	})
+	if len(replayed) != 1 || replayed[0].ID != "bad-1" || replayed[0].Headers[headerError] != nil {
+		t.Fatalf("task queue = %+v, want bad-1 without error headers", replayed)
+	}
+}
+
+func TestRetryWaitsInRetryQueue(t *testing.T) {
+	memory := NewMemory()
+	t.Cleanup(memory.Close)
+	use(t, memory)
+
+	prev := config.RabbitMQRetryDelay
+	config.RabbitMQRetryDelay = 50 * time.Millisecond
+	t.Cleanup(func() { config.RabbitMQRetryDelay = prev })
+
+	retryOrDeadLetter(newTestDelivery(Message{ID: "m1", Body: []byte(`{"id":1}`)}), errTest)
+
+	count := func(queue string) int {
+		total, _ := memory.Scan(queue, 0, func(Delivery) (bool, error) { return true, nil })
+		return total
+	}
+	if count(config.QueueName) != 0 || count(RetryQueue) != 1 {
+		t.Fatal("failed message should wait in the retry queue, not go straight back to the task queue")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for count(config.QueueName) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("message was not returned to the task queue after the retry delay")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count(RetryQueue) != 0 {
+		t.Fatal("expired message left in the retry queue")
+	}
+
+	var retried Message
+	memory.Scan(config.QueueName, 1, func(d Delivery) (bool, error) {
+		retried = d.Message
+		return true, nil
+	})
+	if retryCount(retried.Headers) != 1 || retried.Expiration != 0 {
+		t.Fatalf("retried message = %+v, want x-retry-count 1 and no expiration", retried)
+	}
+}
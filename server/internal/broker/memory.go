@@ -0,0 +1,263 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory — брокер на очередях внутри процесса: сервер запускается одним
+// бинарником без RabbitMQ. Сообщения не переживают перезапуск, поэтому
+// потерянные задачи и события восстанавливаются из БД (outbox результатов).
+type Memory struct {
+	mu     sync.Mutex
+	queues map[string]*memoryQueue
+	since  time.Time
+	done   chan struct{}
+	closed bool
+}
+
+type memoryQueue struct {
+	name       string
+	msgs       []*Message
+	ready      chan struct{} // сигнал ожидающему потребителю, что в очереди есть сообщения
+	deadLetter *Route        // куда перекладываются истёкшие сообщения
+}
+
+// NewMemory создаёт брокер в памяти с очередями и привязками сервера
+func NewMemory() *Memory {
+	m := &Memory{
+		queues: make(map[string]*memoryQueue),
+		since:  time.Now(),
+		done:   make(chan struct{}),
+	}
+	for _, q := range Queues {
+		m.queue(q.Name).deadLetter = q.DeadLetter
+	}
+	return m
+}
+
+// queue возвращает очередь, объявляя её при первом обращении; вызывается под m.mu
+func (m *Memory) queue(name string) *memoryQueue {
+	q := m.queues[name]
+	if q == nil {
+		q = &memoryQueue{name: name, ready: make(chan struct{}, 1)}
+		m.queues[name] = q
+	}
+	return q
+}
+
+func (q *memoryQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Memory) Publish(ctx context.Context, exchange, routingKey string, msgs ...Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrNotConnected
+	}
+
+	for _, q := range m.route(exchange, routingKey) {
+		for _, msg := range msgs {
+			queued := copyMessage(msg)
+			q.msgs = append(q.msgs, queued)
+			if queued.Expiration > 0 {
+				name := q.name
+				time.AfterFunc(queued.Expiration, func() { m.expire(name, queued) })
+			}
+		}
+		q.signal()
+	}
+	return nil
+}
+
+// expire убирает из очереди сообщение с истёкшим сроком и, как RabbitMQ,
+// перекладывает его по DeadLetter очереди без срока жизни
+func (m *Memory) expire(queue string, msg *Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	q := m.queue(queue)
+	for i, queued := range q.msgs {
+		if queued != msg {
+			continue
+		}
+		q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+		if q.deadLetter == nil {
+			return
+		}
+		dead := copyMessage(*msg)
+		dead.Expiration = 0
+		for _, target := range m.route(q.deadLetter.Exchange, q.deadLetter.Key) {
+			target.msgs = append(target.msgs, dead)
+			target.signal()
+		}
+		return
+	}
+}
+
+// route возвращает очереди, в которые попадает сообщение. Как и в RabbitMQ,
+// сообщение без подходящей очереди отбрасывается.
+func (m *Memory) route(exchange, routingKey string) []*memoryQueue {
+	if exchange == "" {
+		if q := m.queues[routingKey]; q != nil {
+			return []*memoryQueue{q}
+		}
+		return nil
+	}
+	var queues []*memoryQueue
+	for _, b := range Bindings {
+		if b.Exchange == exchange && (b.Kind == "fanout" || matchTopic(b.Key, routingKey)) {
+			queues = append(queues, m.queue(b.Queue))
+		}
+	}
+	return queues
+}
+
+// matchTopic сопоставляет ключ с шаблоном привязки topic-обменника:
+// * — ровно одно слово, # — ноль или больше слов
+func matchTopic(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+	if pattern[0] == "#" {
+		for i := 0; i <= len(key); i++ {
+			if matchWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(key) == 0 || (pattern[0] != "*" && pattern[0] != key[0]) {
+		return false
+	}
+	return matchWords(pattern[1:], key[1:])
+}
+
+func (m *Memory) Consume(queue string, handle func(d Delivery)) {
+	for {
+		msg, ok := m.next(queue)
+		if !ok {
+			return
+		}
+		handle(NewDelivery(*msg,
+			func() error { return nil },
+			func(requeue bool) error {
+				if requeue {
+					m.requeue(queue, msg)
+				}
+				return nil
+			}))
+	}
+}
+
+// next ждёт и забирает первое сообщение очереди; false — брокер закрыт
+func (m *Memory) next(queue string) (*Message, bool) {
+	for {
+		m.mu.Lock()
+		q := m.queue(queue)
+		if m.closed {
+			m.mu.Unlock()
+			return nil, false
+		}
+		if len(q.msgs) > 0 {
+			msg := q.msgs[0]
+			q.msgs = q.msgs[1:]
+			if len(q.msgs) > 0 {
+				// Пусть очередь разбирают и другие потребители
+				q.signal()
+			}
+			m.mu.Unlock()
+			return msg, true
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-m.done:
+		}
+	}
+}
+
+// requeue возвращает отклонённое сообщение в начало очереди
+func (m *Memory) requeue(queue string, msg *Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.queue(queue)
+	q.msgs = append([]*Message{msg}, q.msgs...)
+	q.signal()
+}
+
+func (m *Memory) Scan(queue string, limit int, visit func(d Delivery) (stop bool, err error)) (int, error) {
+	m.mu.Lock()
+	q := m.queue(queue)
+	total := len(q.msgs)
+	msgs := append([]*Message(nil), q.msgs[:min(limit, total)]...)
+	m.mu.Unlock()
+
+	for _, msg := range msgs {
+		d := NewDelivery(*msg,
+			func() error {
+				m.remove(queue, msg)
+				return nil
+			},
+			func(bool) error { return nil })
+		stop, err := visit(d)
+		if err != nil || stop {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// remove удаляет сообщение из очереди (подтверждение при просмотре)
+func (m *Memory) remove(queue string, msg *Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.queue(queue)
+	for i, queued := range q.msgs {
+		if queued == msg {
+			q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *Memory) Status() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return State{Kind: "memory", Connected: !m.closed, Since: m.since}
+}
+
+func (m *Memory) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.done)
+	}
+}
+
+// copyMessage копирует сообщение, чтобы очереди не делили заголовки
+func copyMessage(msg Message) *Message {
+	headers := make(map[string]interface{}, len(msg.Headers))
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	msg.Headers = headers
+	return &msg
+}
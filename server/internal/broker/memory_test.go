@@ -0,0 +1,147 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errTest = errors.New("test error")
+
+func newTestDelivery(msg Message) Delivery {
+	return NewDelivery(msg, func() error { return nil }, func(bool) error { return nil })
+}
+
+func TestMatchTopic(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"task.#", "task.solved", true},
+		{"task.#", "task", true},
+		{"task.*", "task.solved", true},
+		{"task.*", "task.solved.late", false},
+		{"task.solved", "task.failed", false},
+		{"#.failed", "task.failed", true},
+	} {
+		if got := matchTopic(tc.pattern, tc.key); got != tc.want {
+			t.Errorf("matchTopic(%q, %q) = %v, want %v", tc.pattern, tc.key, got, tc.want)
+		}
+	}
+}
+
+// receive забирает одно сообщение очереди или падает по таймауту
+func receive(t *testing.T, m *Memory, queue string) Delivery {
+	t.Helper()
+	got := make(chan Delivery, 1)
+	go func() {
+		msg, ok := m.next(queue)
+		if ok {
+			got <- NewDelivery(*msg, func() error { return nil }, func(requeue bool) error {
+				if requeue {
+					m.requeue(queue, msg)
+				}
+				return nil
+			})
+		}
+	}()
+	select {
+	case d := <-got:
+		return d
+	case <-time.After(time.Second):
+		t.Fatalf("no message in %s", queue)
+		return Delivery{}
+	}
+}
+
+func TestMemoryRoutesAndRequeues(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+	ctx := context.Background()
+
+	if err := m.Publish(ctx, "captcha_results", "task.solved", Message{Body: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+	// В очередь без привязки сообщение не попадает
+	m.Publish(ctx, "captcha_results", "worker.online", Message{Body: []byte("ignored")})
+	m.Publish(ctx, "", "no-such-queue", Message{Body: []byte("ignored")})
+
+	d := receive(t, m, "captcha_results.server")
+	if string(d.Body) != "1" {
+		t.Fatalf("received %q, want 1", d.Body)
+	}
+	d.Nack(true)
+	if d := receive(t, m, "captcha_results.server"); string(d.Body) != "1" {
+		t.Fatalf("after requeue received %q, want 1", d.Body)
+	}
+	if total, _ := m.Scan("captcha_results.server", 10, func(Delivery) (bool, error) { return false, nil }); total != 0 {
+		t.Fatalf("%d messages left, want 0", total)
+	}
+
+	m.Close()
+	if err := m.Publish(ctx, "", "captcha_tasks", Message{}); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Publish() after Close = %v, want ErrNotConnected", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		m.Consume("captcha_tasks", func(Delivery) {})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Consume() did not return after Close")
+	}
+}
+
+// use подменяет брокер сервера на время теста
+func use(t *testing.T, b Broker) {
+	t.Helper()
+	mu.Lock()
+	prev := current
+	current = b
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		current = prev
+		mu.Unlock()
+	})
+}
+
+func TestInitPanicsOnSecondCall(t *testing.T) {
+	mu.Lock()
+	prev, prevInitialized := current, initialized
+	current, initialized = nil, false
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		current, initialized = prev, prevInitialized
+		mu.Unlock()
+	})
+
+	memory := NewMemory()
+	t.Cleanup(memory.Close)
+	Init(memory)
+	if Default() != Broker(memory) {
+		t.Fatal("Default() does not return the broker passed to Init")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("second Init() did not panic")
+		}
+	}()
+	Init(NewMemory())
+}
+
+func TestDefaultPanicsBeforeInit(t *testing.T) {
+	use(t, nil)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Default() before Init() did not panic")
+		}
+	}()
+	Default()
+}
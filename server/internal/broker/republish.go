@@ -0,0 +1,133 @@
+package broker
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// Сколько неотправленных задач публикуется за один проход
+const republishBatch = 100
+
+// QueueTasks публикует только что созданные задачи в очередь задач и отмечает
+// их отправленными (tasks.queued_at). Задача уже сохранена в БД, поэтому
+// ошибка публикации не возвращается клиенту: неотправленные задачи повторно
+// публикует RunTaskRepublisher.
+func QueueTasks(batch ...*models.CaptchaTask) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := PublishTasks(ctx, batch...); err != nil {
+		if !errors.Is(err, ErrNotConnected) {
+			log.Printf("❌ Ошибка отправки задач в очередь, повторим позже: %v", err)
+		}
+		return
+	}
+	ids := make([]int64, len(batch))
+	for i, task := range batch {
+		ids[i] = task.ID
+	}
+	if err := markQueued(ids); err != nil {
+		log.Println("Ошибка отметки задач отправленными:", err)
+	}
+}
+
+// RunTaskRepublisher периодически публикует ожидающие задачи, которые не
+// удалось отправить в очередь при создании
+func RunTaskRepublisher() {
+	ticker := time.NewTicker(config.TaskRepublishInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		republishPending()
+	}
+}
+
+func republishPending() {
+	for {
+		batch, err := loadUnqueued()
+		if err != nil {
+			log.Println("Ошибка загрузки неотправленных задач:", err)
+			return
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = PublishTasks(ctx, batch...)
+		cancel()
+		if err != nil {
+			if !errors.Is(err, ErrNotConnected) {
+				log.Println("Ошибка повторной отправки задач в очередь:", err)
+			}
+			return
+		}
+
+		ids := make([]int64, len(batch))
+		for i, task := range batch {
+			ids[i] = task.ID
+		}
+		if err := markQueued(ids); err != nil {
+			log.Println("Ошибка отметки задач отправленными:", err)
+			return
+		}
+		log.Printf("📤 Повторно отправлено задач в очередь: %d", len(batch))
+		if len(batch) < republishBatch {
+			return
+		}
+	}
+}
+
+// loadUnqueued загружает ожидающие задачи, ещё не отправленные в очередь
+func loadUnqueued() ([]*models.CaptchaTask, error) {
+	rows, err := config.DB.Query("SELECT id FROM tasks WHERE status = ? AND queued_at IS NULL ORDER BY id LIMIT ?",
+		models.StatusPending, republishBatch)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	batch := make([]*models.CaptchaTask, 0, len(ids))
+	for _, id := range ids {
+		task, err := tasks.Get(id)
+		if errors.Is(err, tasks.ErrTaskNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, task)
+	}
+	return batch, nil
+}
+
+func markQueued(ids []int64) error {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	_, err := config.DB.Exec("UPDATE tasks SET queued_at = datetime('now') WHERE id IN ("+placeholders+")", args...)
+	return err
+}
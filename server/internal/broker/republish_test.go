@@ -0,0 +1,45 @@
+package broker
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/db"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// offline — брокер, который недоступен, как во время переподключения
+type offline struct{ *Memory }
+
+func (offline) Publish(context.Context, string, string, ...Message) error { return ErrNotConnected }
+
+func TestUnqueuedTasksAreRepublished(t *testing.T) {
+	config.DBPath = filepath.Join(t.TempDir(), "test.db")
+	db.DB_Connect()
+	t.Cleanup(func() { config.DB.Close() })
+
+	memory := NewMemory()
+	t.Cleanup(memory.Close)
+	use(t, offline{memory})
+
+	task := &models.CaptchaTask{UserID: 1, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+	QueueTasks(task)
+	if batch, err := loadUnqueued(); err != nil || len(batch) != 1 {
+		t.Fatalf("loadUnqueued() = %d tasks, %v; want the task that failed to publish", len(batch), err)
+	}
+
+	use(t, memory)
+	republishPending()
+	if batch, err := loadUnqueued(); err != nil || len(batch) != 0 {
+		t.Fatalf("loadUnqueued() after republish = %d tasks, %v; want none", len(batch), err)
+	}
+	queued, err := memory.Scan(config.QueueName, 10, func(Delivery) (bool, error) { return false, nil })
+	if err != nil || queued != 1 {
+		t.Fatalf("queue holds %d messages, %v; want 1", queued, err)
+	}
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envString читает строковый параметр из окружения
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt читает целочисленный параметр из окружения
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%q, используется %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// envDuration читает длительность (например "90s", "2m") из окружения
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%q, используется %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// envFloat читает дробный параметр из окружения
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%q, используется %g", key, v, def)
+		return def
+	}
+	return f
+}
+
+// envBool читает логический параметр ("true", "0", ...) из окружения
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Некорректное значение %s=%q, используется %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+// envDurationMap читает набор длительностей вида "key=90s,other=2m" из
+// окружения; некорректные пары пропускаются
+func envDurationMap(key, def string) map[string]time.Duration {
+	v := envString(key, def)
+	result := make(map[string]time.Duration)
+	for _, pair := range strings.Split(v, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, raw, ok := strings.Cut(pair, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if !ok || err != nil {
+			log.Printf("Некорректное значение в %s: %q, пропущено", key, pair)
+			continue
+		}
+		result[strings.TrimSpace(name)] = d
+	}
+	return result
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"captcha-solver/internal/config"
 	"database/sql"
+	"fmt"
 	"log"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -11,7 +12,10 @@ import (
 var err error
 
 func DB_Connect() {
-	config.DB, err = sql.Open("sqlite3", "./app.db")
+	// WAL и busy_timeout позволяют параллельным воркерам писать в базу,
+	// не получая "database is locked"; _txlock=immediate берёт блокировку
+	// на запись в начале транзакции, исключая взаимоблокировку при её повышении
+	config.DB, err = sql.Open("sqlite3", config.DBPath+"?_journal_mode=WAL&_busy_timeout=10000&_txlock=immediate")
 	if err != nil {
 		log.Fatalf("Error opening DB: %v", err)
 	}
@@ -31,6 +35,10 @@ func createTables() error {
 		role TEXT NOT NULL,
 		api_key TEXT UNIQUE,
 		balance REAL NOT NULL DEFAULT 0,
+		webhook_secret TEXT,
+		tier TEXT NOT NULL DEFAULT 'standard',
+		share_weight INTEGER NOT NULL DEFAULT 1,
+		allowed_types TEXT,
 		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
 		updated_at DATETIME NOT NULL DEFAULT (datetime('now'))
 	)
@@ -55,6 +63,16 @@ func createTables() error {
 		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
 		updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
 		solved_at DATETIME,
+		lease_expires_at DATETIME,
+		callback_url TEXT,
+		public_id TEXT,
+		price REAL,
+		payout REAL,
+		assigned_at DATETIME,
+		deadline_at DATETIME,
+		expires_at DATETIME,
+		priority INTEGER NOT NULL DEFAULT 1,
+		queued_at DATETIME,
 		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
 		FOREIGN KEY(solver_id) REFERENCES users(id) ON DELETE SET NULL
 	)
@@ -63,6 +81,155 @@ func createTables() error {
 		return err
 	}
 
+	if err := migrateTables(); err != nil {
+		return err
+	}
+
+	// Create webhook delivery tables
+	_, err = config.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		url TEXT NOT NULL,
+		event TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		delivered_at DATETIME,
+		FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		delivery_id INTEGER NOT NULL,
+		status_code INTEGER,
+		error TEXT,
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY(delivery_id) REFERENCES webhook_deliveries(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_user_id ON webhook_deliveries(user_id);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery_id ON webhook_attempts(delivery_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create billing ledger tables
+	_, err = config.DB.Exec(`
+	CREATE TABLE IF NOT EXISTS ledger_transactions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		task_id INTEGER,
+		memo TEXT,
+		created_by INTEGER,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE SET NULL,
+		FOREIGN KEY(created_by) REFERENCES users(id) ON DELETE SET NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS ledger_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		transaction_id INTEGER NOT NULL,
+		account TEXT NOT NULL,
+		user_id INTEGER,
+		amount REAL NOT NULL,
+		FOREIGN KEY(transaction_id) REFERENCES ledger_transactions(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS solution_reports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL UNIQUE,
+		user_id INTEGER NOT NULL,
+		solver_id INTEGER NOT NULL,
+		reason TEXT,
+		refunded REAL NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY(solver_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_solution_reports_solver_id ON solution_reports(solver_id);
+
+	CREATE TABLE IF NOT EXISTS worker_stats (
+		user_id INTEGER PRIMARY KEY,
+		solved INTEGER NOT NULL DEFAULT 0,
+		reported INTEGER NOT NULL DEFAULT 0,
+		abandoned INTEGER NOT NULL DEFAULT 0,
+		median_solve_seconds REAL,
+		score REAL NOT NULL DEFAULT 0,
+		last_assigned_at DATETIME,
+		updated_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS worker_solve_times (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		seconds REAL NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_worker_solve_times_user_id ON worker_solve_times(user_id, id);
+
+	CREATE TABLE IF NOT EXISTS prices (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		captcha_type TEXT NOT NULL,
+		client_price REAL NOT NULL,
+		worker_payout REAL NOT NULL,
+		effective_from DATETIME NOT NULL DEFAULT (datetime('now')),
+		created_by INTEGER,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		FOREIGN KEY(created_by) REFERENCES users(id) ON DELETE SET NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		task_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		PRIMARY KEY(user_id, key),
+		FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY(task_id) REFERENCES tasks(id) ON DELETE CASCADE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+
+	CREATE TABLE IF NOT EXISTS result_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL,
+		routing_key TEXT NOT NULL,
+		payload TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+		published_at DATETIME,
+		consumed_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_result_events_unpublished ON result_events(id) WHERE published_at IS NULL;
+
+	CREATE TABLE IF NOT EXISTS result_stats (
+		day TEXT NOT NULL,
+		captcha_type TEXT NOT NULL,
+		status TEXT NOT NULL,
+		count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY(day, captcha_type, status)
+	);
+	CREATE INDEX IF NOT EXISTS idx_prices_type_effective ON prices(captcha_type, effective_from);
+	CREATE INDEX IF NOT EXISTS idx_ledger_transactions_task_id ON ledger_transactions(task_id);
+	CREATE INDEX IF NOT EXISTS idx_ledger_entries_transaction_id ON ledger_entries(transaction_id);
+	CREATE INDEX IF NOT EXISTS idx_ledger_entries_account ON ledger_entries(account, user_id);
+	`)
+	if err != nil {
+		return err
+	}
+
 	// Create indexes for tasks table
 	_, err = config.DB.Exec(`
 	CREATE INDEX IF NOT EXISTS idx_tasks_user_id ON tasks(user_id);
@@ -72,6 +239,12 @@ func createTables() error {
 	CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
 	CREATE INDEX IF NOT EXISTS idx_tasks_captcha_type ON tasks(captcha_type);
 	CREATE INDEX IF NOT EXISTS idx_tasks_pending ON tasks(status) WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS idx_tasks_queue ON tasks(priority, created_at) WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS idx_tasks_queue_client ON tasks(priority, user_id, created_at) WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS idx_tasks_lease ON tasks(lease_expires_at) WHERE status = 'assigned';
+	CREATE INDEX IF NOT EXISTS idx_tasks_unqueued ON tasks(id) WHERE status = 'pending' AND queued_at IS NULL;
+	CREATE INDEX IF NOT EXISTS idx_tasks_deadline ON tasks(deadline_at) WHERE status IN ('pending', 'assigned');
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_public_id ON tasks(public_id);
 	`)
 	if err != nil {
 		return err
@@ -97,3 +270,58 @@ func createTables() error {
 
 	return nil
 }
+
+// migrateTables добавляет в уже существующие таблицы колонки,
+// появившиеся после первой версии схемы
+func migrateTables() error {
+	columns := []struct{ table, column, definition string }{
+		{"tasks", "lease_expires_at", "DATETIME"},
+		{"tasks", "callback_url", "TEXT"},
+		{"tasks", "public_id", "TEXT"},
+		{"tasks", "price", "REAL"},
+		{"tasks", "payout", "REAL"},
+		{"tasks", "assigned_at", "DATETIME"},
+		{"tasks", "deadline_at", "DATETIME"},
+		{"tasks", "expires_at", "DATETIME"},
+		{"tasks", "priority", "INTEGER NOT NULL DEFAULT 1"},
+		{"tasks", "queued_at", "DATETIME"},
+		{"users", "webhook_secret", "TEXT"},
+		{"users", "tier", "TEXT NOT NULL DEFAULT 'standard'"},
+		{"users", "share_weight", "INTEGER NOT NULL DEFAULT 1"},
+		{"users", "allowed_types", "TEXT"},
+	}
+	for _, col := range columns {
+		if err := addColumn(col.table, col.column, col.definition); err != nil {
+			return err
+		}
+	}
+
+	// Задачам, созданным до появления public_id, выдаём случайный идентификатор
+	_, err := config.DB.Exec("UPDATE tasks SET public_id = 'tsk_' || lower(hex(randomblob(16))) WHERE public_id IS NULL")
+	if err != nil {
+		return err
+	}
+
+	// Старые решённые задачи без времени решения и срока действия токена
+	_, err = config.DB.Exec("UPDATE tasks SET solved_at = updated_at WHERE status = 'solved' AND solved_at IS NULL")
+	if err != nil {
+		return err
+	}
+	_, err = config.DB.Exec("UPDATE tasks SET expires_at = datetime(solved_at, ?) WHERE status = 'solved' AND expires_at IS NULL",
+		fmt.Sprintf("+%d seconds", int(config.TokenValidity.Seconds())))
+	return err
+}
+
+// addColumn выполняет ALTER TABLE ADD COLUMN, если колонки ещё нет
+func addColumn(table, column, definition string) error {
+	var exists int
+	err := config.DB.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists > 0 {
+		return nil
+	}
+	_, err = config.DB.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
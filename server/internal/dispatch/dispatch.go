@@ -0,0 +1,197 @@
+package dispatch
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/reputation"
+	"captcha-solver/internal/tasks"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Worker — исполнитель, подключённый по WebSocket в push-режиме.
+// Свободному исполнителю новые задачи отправляются сервером без get_task.
+type Worker struct {
+	UserID   int64
+	Username string
+
+	send   func(v interface{}) error
+	leases *tasks.Leases // задачи, выданные через это соединение
+	idle   bool
+	types  []string // заявленные типы капчи; пусто — любые
+}
+
+// NewWorker создаёт запись исполнителя; выданные ему задачи добавляются в
+// leases. send должен быть безопасен для вызова из других горутин.
+func NewWorker(userID int64, username string, leases *tasks.Leases, send func(v interface{}) error) *Worker {
+	return &Worker{UserID: userID, Username: username, leases: leases, send: send}
+}
+
+var (
+	mu      sync.Mutex
+	workers []*Worker
+	next    int // позиция round-robin в workers
+
+	// dispatchMu не даёт двум раундам раздачи идти параллельно
+	dispatchMu sync.Mutex
+)
+
+// Start подписывает диспетчер на появление задач в очереди и на задачи,
+// отозванные у исполнителей: такой исполнитель снова свободен
+func Start() {
+	tasks.Subscribe(func(ev tasks.Event) {
+		switch {
+		case ev.To == models.StatusPending:
+			go Dispatch()
+		case ev.Revoked():
+			go free(*ev.SolverID)
+		}
+	})
+}
+
+// SetTypes задаёт типы капчи, которые выдаются исполнителю; пустой список — любые.
+// Чтобы исполнитель сразу получил задачи новых типов, после смены вызовите Dispatch.
+func (w *Worker) SetTypes(types []string) {
+	mu.Lock()
+	w.types = tasks.NormalizeTypes(types)
+	mu.Unlock()
+}
+
+// Register добавляет свободного исполнителя в реестр и сразу пытается выдать ему задачу
+func Register(w *Worker) {
+	mu.Lock()
+	w.idle = true
+	workers = append(workers, w)
+	mu.Unlock()
+
+	Dispatch()
+}
+
+// Unregister удаляет исполнителя из реестра
+func Unregister(w *Worker) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, candidate := range workers {
+		if candidate != w {
+			continue
+		}
+		workers = append(workers[:i], workers[i+1:]...)
+		if next > i {
+			next--
+		}
+		if next >= len(workers) {
+			next = 0
+		}
+		return
+	}
+}
+
+// Ready снова помечает исполнителя свободным, например после отправки решения
+func Ready(w *Worker) {
+	mu.Lock()
+	w.idle = true
+	mu.Unlock()
+
+	Dispatch()
+}
+
+// free помечает свободными все push-соединения исполнителя и раздаёт задачи
+func free(userID int64) {
+	mu.Lock()
+	for _, w := range workers {
+		if w.UserID == userID {
+			w.idle = true
+		}
+	}
+	mu.Unlock()
+
+	Dispatch()
+}
+
+// Dispatch раздаёт ожидающие задачи свободным исполнителям по кругу,
+// пока не закончатся свободные исполнители. Исполнители, которым рейтинг
+// сейчас не позволяет взять задачу или для которых нет задач их типов,
+// пропускаются до следующего раунда: задачу могут взять остальные.
+func Dispatch() {
+	dispatchMu.Lock()
+	defer dispatchMu.Unlock()
+
+	var skipped []*Worker
+	retryScheduled := false
+	defer func() {
+		for _, w := range skipped {
+			setIdle(w)
+		}
+	}()
+
+	for {
+		w, types := takeIdle()
+		if w == nil {
+			return
+		}
+
+		task, err := tasks.Claim(w.UserID, types...)
+		if err == nil {
+			w.leases.Add(task.ID)
+		}
+		if errors.Is(err, reputation.ErrThrottled) || errors.Is(err, reputation.ErrSuspended) {
+			if !retryScheduled && errors.Is(err, reputation.ErrThrottled) {
+				// Повторяем раздачу, когда ограничение истечёт
+				time.AfterFunc(config.ReputationThrottleInterval, Dispatch)
+				retryScheduled = true
+			}
+			skipped = append(skipped, w)
+			continue
+		}
+		if err != nil {
+			// Нет задач подходящих типов — пробуем следующего исполнителя
+			skipped = append(skipped, w)
+			if !errors.Is(err, tasks.ErrNoTasks) {
+				log.Println("Error claiming task for push dispatch:", err)
+			}
+			continue
+		}
+
+		push := models.Task{
+			Type:    task.CaptchaType,
+			SiteKey: task.SiteKey,
+			URL:     task.TargetURL,
+			TaskId:  task.ID,
+		}
+		if err := w.send(push); err != nil {
+			log.Printf("Error pushing task #%d to worker %s: %v", task.ID, w.Username, err)
+			Unregister(w)
+			if err := tasks.Release(task.ID, w.UserID); err != nil {
+				log.Printf("Error releasing task #%d: %v", task.ID, err)
+			}
+			continue
+		}
+		log.Printf("📤 Task #%d pushed to worker %s (ID: %d)", task.ID, w.Username, w.UserID)
+	}
+}
+
+// takeIdle выбирает следующего свободного исполнителя по кругу, помечает его
+// занятым и возвращает вместе с заявленными им типами капчи
+func takeIdle() (*Worker, []string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i := 0; i < len(workers); i++ {
+		idx := (next + i) % len(workers)
+		if w := workers[idx]; w.idle {
+			w.idle = false
+			next = (idx + 1) % len(workers)
+			return w, w.types
+		}
+	}
+	return nil, nil
+}
+
+func setIdle(w *Worker) {
+	mu.Lock()
+	w.idle = true
+	mu.Unlock()
+}
@@ -0,0 +1,115 @@
+package dispatch
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/db"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"path/filepath"
+	"testing"
+)
+
+func setupDB(t *testing.T) {
+	t.Helper()
+	config.DBPath = filepath.Join(t.TempDir(), "test.db")
+	db.DB_Connect()
+	t.Cleanup(func() {
+		config.DB.Close()
+		workers, next = nil, 0
+	})
+}
+
+func TestDispatchRoundRobin(t *testing.T) {
+	setupDB(t)
+
+	received := make(map[int64][]int64)
+	var pool []*Worker
+	for id := int64(1); id <= 3; id++ {
+		workerID := id
+		w := NewWorker(workerID, "worker", tasks.NewLeases(workerID), func(v interface{}) error {
+			received[workerID] = append(received[workerID], v.(models.Task).TaskId)
+			return nil
+		})
+		Register(w)
+		pool = append(pool, w)
+	}
+
+	for i := 0; i < 7; i++ {
+		if err := tasks.Create(&models.CaptchaTask{UserID: 1, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each idle worker gets exactly one task per round
+	Dispatch()
+	for _, w := range pool {
+		if got := len(received[w.UserID]); got != 1 {
+			t.Fatalf("worker %d got %d tasks after first round, want 1", w.UserID, got)
+		}
+	}
+
+	for round := 0; round < 2; round++ {
+		for _, w := range pool {
+			Ready(w)
+		}
+	}
+
+	seen := make(map[int64]bool)
+	total := 0
+	for _, ids := range received {
+		for _, id := range ids {
+			if seen[id] {
+				t.Fatalf("task #%d pushed twice", id)
+			}
+			seen[id] = true
+			total++
+		}
+	}
+	if total != 7 {
+		t.Fatalf("pushed %d tasks, want 7", total)
+	}
+}
+
+func TestDispatchTriesEveryIdleWorker(t *testing.T) {
+	setupDB(t)
+
+	received := make(map[int64][]int64)
+	for id := int64(1); id <= 2; id++ {
+		workerID := id
+		w := NewWorker(workerID, "worker", tasks.NewLeases(workerID), func(v interface{}) error {
+			received[workerID] = append(received[workerID], v.(models.Task).TaskId)
+			return nil
+		})
+		if workerID == 1 {
+			w.SetTypes([]string{"recaptcha"})
+		} else {
+			w.SetTypes([]string{"hcaptcha"})
+		}
+		Register(w)
+	}
+
+	task := &models.CaptchaTask{UserID: 1, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+
+	// The recaptcha worker is first in the round; the task must still reach the hcaptcha worker
+	mu.Lock()
+	next = 0
+	mu.Unlock()
+	Dispatch()
+	if len(received[1]) != 0 {
+		t.Fatalf("recaptcha worker got %v", received[1])
+	}
+	if len(received[2]) != 1 || received[2][0] != task.ID {
+		t.Fatalf("hcaptcha worker got %v, want [%d]", received[2], task.ID)
+	}
+
+	// The worker without a matching task stays idle for the next round
+	mu.Lock()
+	idle := workers[0].idle
+	mu.Unlock()
+	if !idle {
+		t.Fatal("recaptcha worker should stay idle")
+	}
+}
@@ -1,10 +1,20 @@
 package handlers
 
 import (
+	"captcha-solver/internal/billing"
+	"captcha-solver/internal/broker"
 	"captcha-solver/internal/config"
+	"captcha-solver/internal/dispatch"
 	"captcha-solver/internal/models"
+	"captcha-solver/internal/reports"
+	"captcha-solver/internal/reputation"
+	"captcha-solver/internal/results"
+	"captcha-solver/internal/tasks"
 	"captcha-solver/internal/utils"
+	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -19,15 +29,91 @@ func ShowAdminDashboard(c *fiber.Ctx) error {
 		return c.Status(500).SendString("Error getting users count")
 	}
 
+	typeStats, err := billing.StatsByType()
+	if err != nil {
+		log.Printf("Error loading pricing stats: %v", err)
+	}
+	resultStats, err := results.StatsByType(7)
+	if err != nil {
+		log.Printf("Error loading result stats: %v", err)
+	}
+
 	return c.Render("admin/dashboard", fiber.Map{
-		"Title":      "Admin Dashboard",
-		"User":       c.Locals("user").(*models.User),
-		"TotalUsers": totalUsers,
+		"Title":       "Admin Dashboard",
+		"User":        c.Locals("user").(*models.User),
+		"TotalUsers":  totalUsers,
+		"TypeStats":   typeStats,
+		"ResultStats": resultStats,
+	}, "layout")
+}
+
+// Прайс-лист по типам капчи
+func ShowPricesAdmin(c *fiber.Ctx) error {
+	prices, err := billing.ListPrices()
+	if err != nil {
+		return c.Status(500).SendString("Ошибка получения прайс-листа")
+	}
+	typeStats, err := billing.StatsByType()
+	if err != nil {
+		return c.Status(500).SendString("Ошибка получения прайс-листа")
+	}
+
+	return c.Render("admin/prices", fiber.Map{
+		"Title":     "Pricing",
+		"User":      c.Locals("user").(*models.User),
+		"Prices":    prices,
+		"TypeStats": typeStats,
 	}, "layout")
 }
 
+// Добавление цены; effective_from задаётся в UTC, пустое значение — «сейчас»
+func CreatePrice(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+
+	var price models.Price
+	price.CaptchaType = tasks.NormalizeType(c.FormValue("captcha_type"))
+	if _, err := fmt.Sscan(c.FormValue("client_price"), &price.ClientPrice); err != nil {
+		return c.Status(400).SendString("Некорректная цена для клиента")
+	}
+	if _, err := fmt.Sscan(c.FormValue("worker_payout"), &price.WorkerPayout); err != nil {
+		return c.Status(400).SendString("Некорректное вознаграждение исполнителю")
+	}
+	if raw := c.FormValue("effective_from"); raw != "" {
+		effectiveFrom, err := time.Parse("2006-01-02T15:04", raw)
+		if err != nil {
+			return c.Status(400).SendString("Некорректная дата начала действия")
+		}
+		price.EffectiveFrom = effectiveFrom.Format("2006-01-02 15:04:05")
+	}
+	price.CreatedBy = &admin.ID
+
+	if err := billing.AddPrice(&price); err != nil {
+		if errors.Is(err, billing.ErrInvalidPrice) {
+			return c.Status(400).SendString("Цены должны быть неотрицательными, а вознаграждение не больше цены для клиента")
+		}
+		return c.Status(500).SendString("Ошибка сохранения цены")
+	}
+	log.Printf("💰 Admin %s set %s price %.6f / payout %.6f", admin.Username, price.CaptchaType, price.ClientPrice, price.WorkerPayout)
+	return c.Redirect("/admin/prices")
+}
+
+// Удаление запланированной цены
+func DeletePrice(c *fiber.Ctx) error {
+	var priceID int64
+	if _, err := fmt.Sscan(c.Params("id"), &priceID); err != nil {
+		return c.Status(400).SendString("Неверный ID цены")
+	}
+	if err := billing.DeletePrice(priceID); err != nil {
+		if errors.Is(err, billing.ErrPriceNotFound) {
+			return c.Status(404).SendString("Цена не найдена или уже действует")
+		}
+		return c.Status(500).SendString("Ошибка удаления цены")
+	}
+	return c.SendString("OK")
+}
+
 func ShowUsersAdmin(c *fiber.Ctx) error {
-	rows, err := config.DB.Query("SELECT id, username, role, api_key, created_at FROM users")
+	rows, err := config.DB.Query("SELECT id, username, role, api_key, balance, tier, share_weight, COALESCE(allowed_types, ''), created_at FROM users")
 	if err != nil {
 		return c.Status(500).SendString("Error getting users")
 	}
@@ -36,15 +122,28 @@ func ShowUsersAdmin(c *fiber.Ctx) error {
 	var userList []*models.User
 	for rows.Next() {
 		var user models.User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.APIKey, &user.CreatedAt); err != nil {
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.APIKey, &user.Balance, &user.Tier, &user.ShareWeight, &user.AllowedTypes, &user.CreatedAt); err != nil {
 			continue
 		}
 		userList = append(userList, &user)
 	}
+
+	accuracy, err := reports.AccuracyByWorker()
+	if err != nil {
+		log.Printf("Error loading worker accuracy: %v", err)
+	}
+	reputations, err := reputation.All()
+	if err != nil {
+		log.Printf("Error loading worker reputation: %v", err)
+	}
+
 	return c.Render("admin/users", fiber.Map{
-		"Title": "Manage Users",
-		"User":  c.Locals("user").(*models.User),
-		"Users": userList,
+		"Title":      "Manage Users",
+		"User":       c.Locals("user").(*models.User),
+		"Users":      userList,
+		"Accuracy":   accuracy,
+		"Reputation": reputations,
+		"Tiers":      billing.Tiers,
 	}, "layout")
 }
 
@@ -111,10 +210,162 @@ func DeleteUser(c *fiber.Ctx) error {
 	return c.SendString("OK")
 }
 
+// Ручная корректировка баланса пользователя через журнал
+func AdjustBalance(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+	var userID int64
+	if _, err := fmt.Sscan(c.Params("id"), &userID); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var req struct {
+		Amount float64 `json:"amount"`
+		Memo   string  `json:"memo"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request format"})
+	}
+	if req.Memo == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Memo is required"})
+	}
+
+	if err := billing.Adjust(userID, req.Amount, req.Memo, admin.ID); err != nil {
+		switch {
+		case errors.Is(err, billing.ErrInvalidAmount):
+			return c.Status(400).JSON(fiber.Map{"error": "Amount must be a non-zero number"})
+		case errors.Is(err, billing.ErrInsufficientFunds):
+			return c.Status(400).JSON(fiber.Map{"error": "Adjustment would make the balance negative"})
+		case errors.Is(err, sql.ErrNoRows):
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		log.Printf("Error adjusting balance of user %d: %v", userID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to adjust balance"})
+	}
+	log.Printf("💰 Admin %s adjusted balance of user %d by %.6f: %s", admin.Username, userID, req.Amount, req.Memo)
+	return c.JSON(fiber.Map{"status": "success"})
+}
+
+// SetUserTier меняет тариф клиента, от которого зависит приоритет его задач
+func SetUserTier(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+	var userID int64
+	if _, err := fmt.Sscan(c.Params("id"), &userID); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var req struct {
+		Tier string `json:"tier"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request format"})
+	}
+
+	if err := billing.SetTier(userID, req.Tier); err != nil {
+		switch {
+		case errors.Is(err, billing.ErrInvalidTier):
+			return c.Status(400).JSON(fiber.Map{"error": "Unknown tier"})
+		case errors.Is(err, sql.ErrNoRows):
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		log.Printf("Error setting tier of user %d: %v", userID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to set tier"})
+	}
+	log.Printf("🏷️ Admin %s set tier of user %d to %s", admin.Username, userID, req.Tier)
+	return c.JSON(fiber.Map{"status": "success"})
+}
+
+// SetUserShareWeight меняет вес клиента при разделении очереди между клиентами
+func SetUserShareWeight(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+	var userID int64
+	if _, err := fmt.Sscan(c.Params("id"), &userID); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var req struct {
+		Weight int `json:"weight"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request format"})
+	}
+
+	if err := tasks.SetShareWeight(userID, req.Weight); err != nil {
+		switch {
+		case errors.Is(err, tasks.ErrInvalidShareWeight):
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, sql.ErrNoRows):
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		log.Printf("Error setting share weight of user %d: %v", userID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to set share weight"})
+	}
+	log.Printf("⚖️ Admin %s set share weight of user %d to %d", admin.Username, userID, req.Weight)
+	return c.JSON(fiber.Map{"status": "success"})
+}
+
+// SetUserAllowedTypes ограничивает типы капчи, которые выдаются исполнителю;
+// пустая строка снимает ограничение
+func SetUserAllowedTypes(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+	var userID int64
+	if _, err := fmt.Sscan(c.Params("id"), &userID); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var req struct {
+		CaptchaTypes string `json:"captcha_types"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request format"})
+	}
+
+	types := tasks.ParseTypes(req.CaptchaTypes)
+	if err := tasks.SetAllowedTypes(userID, types); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		log.Printf("Error setting allowed captcha types of user %d: %v", userID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to set captcha types"})
+	}
+	log.Printf("🧩 Admin %s restricted user %d to captcha types %v", admin.Username, userID, types)
+	dispatch.Dispatch()
+	return c.JSON(fiber.Map{"status": "success", "captcha_types": types})
+}
+
+// ResetReputation обнуляет рейтинг исполнителя и снимает с него отстранение
+func ResetReputation(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+	var userID int64
+	if _, err := fmt.Sscan(c.Params("id"), &userID); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var role string
+	err := config.DB.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reset reputation"})
+	}
+	if role != "worker" {
+		return c.Status(400).JSON(fiber.Map{"error": "Only workers have a reputation"})
+	}
+
+	if err := reputation.Reset(userID); err != nil {
+		log.Printf("Error resetting reputation of user %d: %v", userID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reset reputation"})
+	}
+	log.Printf("📊 Admin %s reset reputation of user %d", admin.Username, userID)
+	dispatch.Dispatch()
+	return c.JSON(fiber.Map{"status": "success"})
+}
+
 // ShowAdminTaskList shows all tasks for admin
 func ShowAdminTaskList(c *fiber.Ctx) error {
 	rows, err := config.DB.Query(`
-		SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response, created_at 
+		SELECT id, public_id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response, status, created_at, price
 		FROM tasks 
 		ORDER BY created_at DESC
 	`)
@@ -123,38 +374,95 @@ func ShowAdminTaskList(c *fiber.Ctx) error {
 	}
 	defer rows.Close()
 
-	var tasks []*models.CaptchaTask
+	var taskList []*models.CaptchaTask
 	for rows.Next() {
 		var task models.CaptchaTask
 		if err := rows.Scan(
 			&task.ID,
+			&task.PublicID,
 			&task.UserID,
 			&task.SolverID,
 			&task.CaptchaType,
 			&task.SiteKey,
 			&task.TargetURL,
 			&task.CaptchaResponse,
+			&task.Status,
 			&task.CreatedAt,
+			&task.Price,
 		); err != nil {
 			continue
 		}
-		tasks = append(tasks, &task)
+		taskList = append(taskList, &task)
+	}
+
+	refunds, err := billing.AllRefundsByTask()
+	if err != nil {
+		log.Printf("Error loading refunds: %v", err)
 	}
 
 	return c.Render("admin/tasks", fiber.Map{
-		"Title": "Task Management",
-		"User":  c.Locals("user").(*models.User),
-		"Tasks": tasks,
+		"Title":   "Task Management",
+		"User":    c.Locals("user").(*models.User),
+		"Tasks":   taskList,
+		"Refunds": refunds,
 	}, "layout")
 }
 
-// DeleteTask deletes a task by ID
+// Ручной возврат средств клиенту по спорной задаче
+func RefundTask(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+	var taskID int64
+	if _, err := fmt.Sscan(c.Params("id"), &taskID); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid task ID"})
+	}
+
+	var req struct {
+		Memo string `json:"memo"`
+	}
+	c.BodyParser(&req)
+	if req.Memo == "" {
+		req.Memo = "manual refund"
+	}
+
+	amount, err := billing.Refund(taskID, req.Memo, admin.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, tasks.ErrTaskNotFound):
+			return c.Status(404).JSON(fiber.Map{"error": "Task not found"})
+		case errors.Is(err, billing.ErrTaskNotFinished):
+			return c.Status(409).JSON(fiber.Map{"error": "Task is still in progress"})
+		case errors.Is(err, billing.ErrNothingToRefund):
+			return c.Status(409).JSON(fiber.Map{"error": "Task is already refunded"})
+		}
+		log.Printf("Error refunding task #%d: %v", taskID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to refund task"})
+	}
+	log.Printf("💰 Admin %s refunded %.6f for task #%d: %s", admin.Username, amount, taskID, req.Memo)
+	return c.JSON(fiber.Map{"status": "success", "amount": amount})
+}
+
+// DeleteTask deletes a task by ID. An unfinished task is cancelled first so
+// that its hold is released through the usual transition hooks.
 func DeleteTask(c *fiber.Ctx) error {
-	taskID := c.Params("id")
-	if taskID == "" {
+	var taskID int64
+	if _, err := fmt.Sscan(c.Params("id"), &taskID); err != nil {
 		return c.Status(400).SendString("Task ID is required")
 	}
 
+	task, err := tasks.Get(taskID)
+	if errors.Is(err, tasks.ErrTaskNotFound) {
+		return c.Status(404).SendString("Task not found")
+	}
+	if err != nil {
+		return c.Status(500).SendString("Error deleting task")
+	}
+	if !tasks.IsTerminal(task.Status) {
+		if err := tasks.Cancel(taskID); err != nil {
+			log.Printf("Error cancelling task #%d before delete: %v", taskID, err)
+			return c.Status(taskErrorStatus(err)).SendString("Error cancelling task")
+		}
+	}
+
 	result, err := config.DB.Exec("DELETE FROM tasks WHERE id = ?", taskID)
 	if err != nil {
 		return c.Status(500).SendString("Error deleting task")
@@ -171,3 +479,56 @@ func DeleteTask(c *fiber.Ctx) error {
 
 	return c.SendString("OK")
 }
+
+// deadLettersPageSize — сколько сообщений DLQ показывается на странице
+const deadLettersPageSize = 100
+
+// ShowDeadLetters показывает сообщения из очереди недоставленных (DLQ)
+func ShowDeadLetters(c *fiber.Ctx) error {
+	letters, total, err := broker.DeadLetters(deadLettersPageSize)
+	var brokerError string
+	if err != nil {
+		log.Println("Error reading dead letters:", err)
+		brokerError = err.Error()
+	}
+
+	return c.Render("admin/dead-letters", fiber.Map{
+		"Title":       "Dead Letters",
+		"User":        c.Locals("user").(*models.User),
+		"Letters":     letters,
+		"Total":       total,
+		"BrokerError": brokerError,
+	}, "layout")
+}
+
+// ReplayDeadLetter возвращает сообщение из DLQ в очередь задач
+func ReplayDeadLetter(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+	if err := broker.ReplayDeadLetter(c.Params("id")); err != nil {
+		return c.Status(deadLetterErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	log.Printf("🔁 Admin %s replayed dead letter %s", admin.Username, c.Params("id"))
+	return c.JSON(fiber.Map{"status": "success"})
+}
+
+// DiscardDeadLetter удаляет сообщение из DLQ
+func DiscardDeadLetter(c *fiber.Ctx) error {
+	admin := c.Locals("user").(*models.User)
+	if err := broker.DiscardDeadLetter(c.Params("id")); err != nil {
+		return c.Status(deadLetterErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	log.Printf("🗑️ Admin %s discarded dead letter %s", admin.Username, c.Params("id"))
+	return c.JSON(fiber.Map{"status": "success"})
+}
+
+func deadLetterErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, broker.ErrDeadLetterNotFound):
+		return 404
+	case errors.Is(err, broker.ErrNotConnected):
+		return 503
+	default:
+		log.Println("Dead letter error:", err)
+		return 500
+	}
+}
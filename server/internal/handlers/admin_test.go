@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"captcha-solver/internal/billing"
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/db"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/reputation"
+	"captcha-solver/internal/tasks"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var startOnce sync.Once
+
+func setupDB(t *testing.T) {
+	t.Helper()
+	config.DBPath = filepath.Join(t.TempDir(), "test.db")
+	db.DB_Connect()
+	t.Cleanup(func() { config.DB.Close() })
+	startOnce.Do(func() {
+		billing.Start()
+		reputation.Start()
+	})
+}
+
+func createUser(t *testing.T, username, role string, balance float64) *models.User {
+	t.Helper()
+	res, err := config.DB.Exec("INSERT INTO users (username, password_hash, role, balance) VALUES (?, '', ?, ?)", username, role, balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return &models.User{ID: id, Username: username, Role: role}
+}
+
+// asUser оборачивает обработчик так, как это делает middleware авторизации
+func asUser(user *models.User, handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("user", user)
+		return handler(c)
+	}
+}
+
+func TestDeleteTaskReleasesHold(t *testing.T) {
+	setupDB(t)
+	admin := createUser(t, "root", "admin", 0)
+	client := createUser(t, "client", "client", 1)
+
+	task := &models.CaptchaTask{UserID: client.ID, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	if err := billing.PriceTask(task); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+	if held, _ := billing.Held(client.ID); held == 0 {
+		t.Fatal("expected funds to be held for the pending task")
+	}
+
+	app := fiber.New()
+	app.Delete("/tasks/:id", asUser(admin, DeleteTask))
+	resp, err := app.Test(httptest.NewRequest("DELETE", "/tasks/"+strconv.FormatInt(task.ID, 10), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	if held, _ := billing.Held(client.ID); held != 0 {
+		t.Fatalf("held = %v after delete, want 0", held)
+	}
+	if _, err := tasks.Get(task.ID); err != tasks.ErrTaskNotFound {
+		t.Fatalf("task still exists: %v", err)
+	}
+}
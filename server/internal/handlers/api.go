@@ -1,15 +1,17 @@
 package handlers
 
 import (
-	"captcha-solver/internal/config"
 	"captcha-solver/internal/models"
-	"database/sql"
+	"captcha-solver/internal/reputation"
+	"captcha-solver/internal/tasks"
+	"errors"
 	"log"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// GetNextTaskAPI отримує наступне завдання для робітника
+// GetNextTaskAPI отримує наступне завдання для робітника; ?captcha_types=
+// обмежує типи капчі, які він готовий розв'язувати
 func GetNextTaskAPI(c *fiber.Ctx) error {
 	user := c.Locals("user").(*models.User)
 	if user.Role != "worker" && user.Role != "admin" {
@@ -19,46 +21,32 @@ func GetNextTaskAPI(c *fiber.Ctx) error {
 		})
 	}
 
-	var taskID int64
-	var siteKey, targetURL, captchaType string
-
-	err := config.DB.QueryRow(`
-		SELECT id, captcha_type, sitekey, target_url 
-		FROM tasks 
-		WHERE solver_id IS NULL AND (captcha_response IS NULL OR captcha_response = '')
-		ORDER BY created_at ASC
-		LIMIT 1
-	`).Scan(&taskID, &captchaType, &siteKey, &targetURL)
-
+	claimed, err := tasks.Claim(user.ID, tasks.ParseTypes(c.Query("captcha_types"))...)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, tasks.ErrNoTasks) {
 			return c.Status(404).JSON(fiber.Map{
 				"status":  "no_tasks",
 				"message": "No tasks available",
 			})
 		}
-		log.Println("Error fetching task:", err)
+		if errors.Is(err, reputation.ErrSuspended) || errors.Is(err, reputation.ErrThrottled) {
+			return c.Status(taskErrorStatus(err)).JSON(fiber.Map{
+				"status":  "error",
+				"message": err.Error(),
+			})
+		}
+		log.Println("Error claiming task:", err)
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Database error",
 		})
 	}
 
-	// Assign the task to this worker
-	_, err = config.DB.Exec("UPDATE tasks SET solver_id = ? WHERE id = ?", user.ID, taskID)
-	if err != nil {
-		log.Println("Error assigning task to worker:", err)
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to assign task",
-		})
-	}
-
 	task := models.Task{
-		Type:    captchaType,
-		SiteKey: siteKey,
-		URL:     targetURL,
-		TaskId:  taskID,
+		Type:    claimed.CaptchaType,
+		SiteKey: claimed.SiteKey,
+		URL:     claimed.TargetURL,
+		TaskId:  claimed.ID,
 	}
 
 	return c.JSON(fiber.Map{
@@ -97,13 +85,9 @@ func SubmitSolutionAPI(c *fiber.Ctx) error {
 	}
 
 	// Update the task with the solution
-	_, err := config.DB.Exec(
-		"UPDATE tasks SET captcha_response = ? WHERE id = ? AND solver_id = ?",
-		solution.Solution, solution.TaskID, user.ID)
-
-	if err != nil {
+	if err := tasks.Solve(solution.TaskID, user.ID, solution.Solution); err != nil {
 		log.Println("Error saving solution:", err)
-		return c.Status(500).JSON(fiber.Map{
+		return c.Status(taskErrorStatus(err)).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Failed to save solution",
 		})
@@ -116,8 +100,11 @@ func SubmitSolutionAPI(c *fiber.Ctx) error {
 
 // GetQueueCountAPI отримує кількість завдань в черзі
 func GetQueueCountAPI(c *fiber.Ctx) error {
-	var count int
-	err := config.DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE solver_id IS NULL AND (captcha_response IS NULL OR captcha_response = '')").Scan(&count)
+	count, err := tasks.QueueDepth()
+	var byPriority map[string]int
+	if err == nil {
+		byPriority, err = tasks.QueueDepthByPriority()
+	}
 	if err != nil {
 		log.Println("Error fetching queue count:", err)
 		return c.Status(500).JSON(fiber.Map{
@@ -127,7 +114,8 @@ func GetQueueCountAPI(c *fiber.Ctx) error {
 	}
 
 	return c.JSON(fiber.Map{
-		"status": "success",
-		"count":  count,
+		"status":      "success",
+		"count":       count,
+		"by_priority": byPriority,
 	})
 }
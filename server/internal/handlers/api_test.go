@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/reputation"
+	"captcha-solver/internal/tasks"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestGetNextTaskAPIReportsReputationLimits(t *testing.T) {
+	setupDB(t)
+	worker := createUser(t, "worker", "worker", 0)
+	admin := createUser(t, "root", "admin", 0)
+	config.DB.Exec("INSERT INTO worker_stats (user_id, solved, abandoned, score) VALUES (?, 1, 30, ?)",
+		worker.ID, reputation.Score(1, 0, 30, nil))
+
+	task := &models.CaptchaTask{UserID: admin.ID, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/api/task", asUser(worker, GetNextTaskAPI))
+	app.Post("/admin/users/:id/reputation/reset", asUser(admin, ResetReputation))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/task", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 403 {
+		t.Fatalf("suspended worker: status = %d, want 403", resp.StatusCode)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("POST", "/admin/users/"+strconv.FormatInt(worker.ID, 10)+"/reputation/reset", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("reset: status = %d, want 200", resp.StatusCode)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/api/task", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("after reset: status = %d, want 200", resp.StatusCode)
+	}
+
+	// Тот же исполнитель, но в положении throttled, получает 429 на второй запрос
+	config.DB.Exec("UPDATE worker_stats SET solved = 20, reported = 12, score = ? WHERE user_id = ?",
+		reputation.Score(20, 12, 0, nil), worker.ID)
+	if err := tasks.Create(&models.CaptchaTask{UserID: admin.ID, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = app.Test(httptest.NewRequest("GET", "/api/task", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 429 {
+		t.Fatalf("throttled worker: status = %d, want 429", resp.StatusCode)
+	}
+}
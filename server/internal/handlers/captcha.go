@@ -1,28 +1,44 @@
 package handlers
 
 import (
+	"captcha-solver/internal/billing"
+	"captcha-solver/internal/broker"
 	"captcha-solver/internal/config"
 	"captcha-solver/internal/models"
-	"captcha-solver/internal/rabbitmq"
-	"context"
+	"captcha-solver/internal/notify"
+	"captcha-solver/internal/reports"
+	"captcha-solver/internal/tasks"
+	"captcha-solver/internal/webhooks"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// submitRequest — завдання в запиті клієнта (submit, submit-batch і
+// create_task у WebSocket)
+type submitRequest struct {
+	SiteKey     string `json:"sitekey"`
+	TargetURL   string `json:"target_url"`
+	CaptchaType string `json:"captcha_type"`
+	CallbackURL string `json:"callback_url"`
+	MaxWait     int    `json:"max_wait"` // секунди; 0 — значення за замовчуванням для типу
+	Priority    string `json:"priority"` // ставка: low, normal або high; вище тарифу — з доплатою
+}
+
 // SubmitCaptcha обробляє відправку нової капчі
 func SubmitCaptcha(c *fiber.Ctx) error {
 	log.Printf("📥 Отримано запит на відправку капчі: %s", string(c.Body()))
 
-	var taskData struct {
-		SiteKey     string `json:"sitekey"`
-		TargetURL   string `json:"target_url"`
-		CaptchaType string `json:"captcha_type"`
-	}
+	var taskData submitRequest
 
 	if err := c.BodyParser(&taskData); err != nil {
 		log.Printf("❌ Помилка парсингу запиту: %v", err)
@@ -55,104 +71,322 @@ func SubmitCaptcha(c *fiber.Ctx) error {
 		})
 	}
 
-	if taskData.SiteKey == "" || taskData.TargetURL == "" {
-		log.Printf("❌ Відсутні обов'язкові поля: sitekey=%s, target_url=%s", taskData.SiteKey, taskData.TargetURL)
-		return c.Status(400).JSON(fiber.Map{
+	task, replayed, status, err := createTask(user, &taskData, c.Get("Idempotency-Key"))
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Sitekey and target URL are required",
+			"message": err.Error(),
 		})
 	}
+	return c.JSON(submitResponse(task, replayed))
+}
+
+// createTask перевіряє завдання з запиту клієнта, створює його і ставить у
+// чергу брокера. Якщо клієнт передав idempotencyKey, який він уже
+// використовував з таким самим запитом, нове завдання не створюється, а
+// повертається створене раніше (replayed). Разом з помилкою повертається
+// HTTP-статус для відповіді.
+func createTask(user *models.User, req *submitRequest, idempotencyKey string) (task *models.CaptchaTask, replayed bool, status int, err error) {
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return nil, false, 400, fmt.Errorf("Idempotency key must be at most %d characters", maxIdempotencyKeyLength)
+	}
 
-	if taskData.CaptchaType == "" {
-		taskData.CaptchaType = "hcaptcha"
+	task, status, err = newTask(user, req)
+	if err != nil {
+		return nil, false, status, err
 	}
 
 	// Створення завдання
-	res, err := config.DB.Exec("INSERT INTO tasks (user_id, captcha_type, sitekey, target_url) VALUES (?, ?, ?, ?)",
-		user.ID, taskData.CaptchaType, taskData.SiteKey, taskData.TargetURL)
+	if idempotencyKey != "" {
+		task, replayed, err = tasks.CreateIdempotent(task, idempotencyKey, requestFingerprint(req))
+	} else {
+		err = tasks.Create(task)
+	}
 	if err != nil {
+		switch {
+		case errors.Is(err, billing.ErrInsufficientFunds):
+			log.Printf("❌ Недостатньо коштів у користувача %s: %v", user.Username, err)
+			return nil, false, 402, errors.New("Insufficient funds")
+		case errors.Is(err, tasks.ErrIdempotencyConflict):
+			log.Printf("❌ Ключ ідемпотентності %q користувача %s використано з іншим запитом", idempotencyKey, user.Username)
+			return nil, false, 409, errors.New("Idempotency key was already used with a different request")
+		}
 		log.Printf("❌ Помилка створення завдання: %v", err)
-		return c.Status(500).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to create task",
-		})
+		return nil, false, 500, errors.New("Failed to create task")
+	}
+	if replayed {
+		log.Printf("🔁 Повторний запит з ключем %q: повертаємо завдання #%d користувача %s", idempotencyKey, task.ID, user.Username)
+		return task, true, 200, nil
+	}
+
+	log.Printf("✅ Створено завдання #%d для користувача %s", task.ID, user.Username)
+
+	// Завдання вже збережене і кошти зарезервовані, тому помилка черги
+	// клієнту не повертається: невідправлене завдання перевідправить
+	// broker.RunTaskRepublisher
+	broker.QueueTasks(task)
+	return task, false, 200, nil
+}
+
+// submitResponse — відповідь на створення завдання (REST і WebSocket)
+func submitResponse(task *models.CaptchaTask, replayed bool) fiber.Map {
+	response := fiber.Map{
+		"status":   "success",
+		"task":     task,
+		"price":    task.Price,
+		"priority": tasks.PriorityName(task.Priority),
+	}
+	if replayed {
+		response["replayed"] = true
+	}
+	return response
+}
+
+// maxIdempotencyKeyLength обмежує довжину Idempotency-Key
+const maxIdempotencyKeyLength = 255
+
+// requestFingerprint повертає відбиток запиту на створення завдання: повтор
+// з тим самим Idempotency-Key має збігатися з оригіналом за всіма полями
+func requestFingerprint(req *submitRequest) string {
+	body, _ := json.Marshal(req)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// newTask перевіряє завдання з запиту клієнта і готує його до створення:
+// заповнює значення за замовчуванням, ціну та пріоритет. Разом з помилкою
+// повертається HTTP-статус для відповіді.
+func newTask(user *models.User, req *submitRequest) (*models.CaptchaTask, int, error) {
+	if req.SiteKey == "" || req.TargetURL == "" {
+		log.Printf("❌ Відсутні обов'язкові поля: sitekey=%s, target_url=%s", req.SiteKey, req.TargetURL)
+		return nil, 400, errors.New("Sitekey and target URL are required")
+	}
+
+	// Тип зберігається так само, як його оголошують виконавці (hCaptcha → hcaptcha)
+	req.CaptchaType = tasks.NormalizeType(req.CaptchaType)
+	if req.CaptchaType == "" {
+		req.CaptchaType = "hcaptcha"
+	}
+
+	maxWait, err := parseMaxWait(req.MaxWait)
+	if err != nil {
+		return nil, 400, err
 	}
 
-	taskID, _ := res.LastInsertId()
 	task := &models.CaptchaTask{
-		ID:          taskID,
 		UserID:      user.ID,
-		CaptchaType: taskData.CaptchaType,
-		SiteKey:     taskData.SiteKey,
-		TargetURL:   taskData.TargetURL,
+		CaptchaType: req.CaptchaType,
+		SiteKey:     req.SiteKey,
+		TargetURL:   req.TargetURL,
+		MaxWait:     maxWait,
+	}
+	if req.CallbackURL != "" {
+		if err := webhooks.ValidateURL(req.CallbackURL); err != nil {
+			return nil, 400, err
+		}
+		callbackURL := req.CallbackURL
+		task.CallbackURL = &callbackURL
+	}
+
+	// Фіксуємо ціну за прайс-листом на момент відправки
+	if err := billing.PriceTask(task); err != nil {
+		log.Printf("❌ Помилка визначення ціни: %v", err)
+		return nil, 500, errors.New("Failed to price task")
+	}
+	if req.Priority != "" {
+		level, ok := tasks.ParsePriority(req.Priority)
+		if !ok || billing.Bid(task, level) != nil {
+			return nil, 400, errors.New("Priority must be low, normal or high")
+		}
+	}
+	return task, 200, nil
+}
+
+// SubmitCaptchaBatch створює до config.MaxBatchSize завдань одним запитом:
+// усі вставляються в одній транзакції й публікуються в RabbitMQ одним
+// пакетом. Помилка окремого завдання (валідація, нестача коштів) не скасовує
+// решту — результат повертається для кожного елемента в порядку запиту.
+func SubmitCaptchaBatch(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{
+			"status":  "error",
+			"message": "User not found in context",
+		})
+	}
+	if user.Role != "client" && user.Role != "admin" {
+		return c.Status(403).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Only clients and admins can submit captchas",
+		})
 	}
 
-	log.Printf("✅ Створено завдання #%d для користувача %s", taskID, user.Username)
+	var body struct {
+		Tasks []submitRequest `json:"tasks"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request format",
+		})
+	}
+	if len(body.Tasks) == 0 || len(body.Tasks) > config.MaxBatchSize {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("Batch must contain between 1 and %d tasks", config.MaxBatchSize),
+		})
+	}
 
-	// Відправка в RabbitMQ
-	taskBytes, err := json.Marshal(task)
+	results := make([]fiber.Map, len(body.Tasks))
+	var (
+		batch   []*models.CaptchaTask
+		indexes []int // позиція кожного завдання batch у запиті
+	)
+	for i := range body.Tasks {
+		task, _, err := newTask(user, &body.Tasks[i])
+		if err != nil {
+			results[i] = fiber.Map{"index": i, "status": "error", "message": err.Error()}
+			continue
+		}
+		batch = append(batch, task)
+		indexes = append(indexes, i)
+	}
+
+	errs, err := tasks.CreateBatch(batch)
 	if err != nil {
-		log.Printf("❌ Помилка серіалізації завдання: %v", err)
+		log.Printf("❌ Помилка створення пакета завдань: %v", err)
 		return c.Status(500).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to process task",
+			"message": "Failed to create tasks",
 		})
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	var created []*models.CaptchaTask
+	for j, task := range batch {
+		i := indexes[j]
+		switch {
+		case errs[j] == nil:
+			created = append(created, task)
+			results[i] = fiber.Map{
+				"index":    i,
+				"status":   "success",
+				"task":     task,
+				"price":    task.Price,
+				"priority": tasks.PriorityName(task.Priority),
+			}
+		case errors.Is(errs[j], billing.ErrInsufficientFunds):
+			results[i] = fiber.Map{"index": i, "status": "error", "message": "Insufficient funds"}
+		default:
+			log.Printf("❌ Помилка створення завдання: %v", errs[j])
+			results[i] = fiber.Map{"index": i, "status": "error", "message": "Failed to create task"}
+		}
+	}
+	log.Printf("✅ Створено %d з %d завдань пакета для користувача %s", len(created), len(body.Tasks), user.Username)
+
+	broker.QueueTasks(created...)
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"created": len(created),
+		"failed":  len(body.Tasks) - len(created),
+		"results": results,
+	})
+}
 
-	err = rabbitmq.RabbitMQChannel.PublishWithContext(ctx,
-		"",               // exchange
-		config.QueueName, // routing key
-		false,            // mandatory
-		false,            // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        taskBytes,
+// GetCaptchaResults повертає результати кількох завдань за публічними ID
+// (?ids=id1,id2,...) в порядку запиту. Для недоступних і неіснуючих завдань
+// елемент містить помилку; прострочені токени не повертаються.
+func GetCaptchaResults(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{
+			"status":  "error",
+			"message": "User not found in context",
 		})
-	if err != nil {
-		log.Printf("❌ Помилка відправки в RabbitMQ: %v", err)
-		return c.Status(500).JSON(fiber.Map{
+	}
+
+	var ids []string
+	for _, id := range strings.Split(c.Query("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 || len(ids) > config.MaxBatchSize {
+		return c.Status(400).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to queue task",
+			"message": fmt.Sprintf("ids must list between 1 and %d task IDs", config.MaxBatchSize),
 		})
 	}
 
-	log.Printf("✅ Завдання #%d успішно додано до черги", taskID)
+	results := make([]fiber.Map, len(ids))
+	for i, id := range ids {
+		task, err := tasks.Resolve(id)
+		if err == nil && !tasks.CanView(task, user) {
+			err = tasks.ErrTaskNotFound
+		}
+		switch {
+		case errors.Is(err, tasks.ErrTaskNotFound):
+			results[i] = fiber.Map{"id": id, "status": "error", "message": "Task not found"}
+		case err != nil:
+			log.Printf("Error loading task %s: %v", id, err)
+			results[i] = fiber.Map{"id": id, "status": "error", "message": "Database error"}
+		case task.Stale:
+			task.RedactStale()
+			results[i] = fiber.Map{"id": id, "status": "success", "task": task, "message": "Solution token has expired"}
+		default:
+			results[i] = fiber.Map{"id": id, "status": "success", "task": task}
+		}
+	}
 
 	return c.JSON(fiber.Map{
-		"status": "success",
-		"task":   task,
+		"status":  "success",
+		"results": results,
 	})
 }
 
-// GetCaptchaResult отримує результат капчі за ID
+// GetCaptchaResult отримує результат капчі за публічним ID.
+// Користувач бачить лише ті завдання, до яких має доступ (tasks.CanView);
+// для чужих завдань відповідь та сама, що й для неіснуючих. Після expires_at
+// розв'язок позначається як stale і токен не повертається.
 func GetCaptchaResult(c *fiber.Ctx) error {
-	taskID := c.Params("id")
-	var task models.CaptchaTask
-
-	var solverID sql.NullInt64
-	var captchaResp sql.NullString
-
-	err := config.DB.QueryRow(`
-		SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response, 
-		       datetime(created_at, 'localtime') as created_at 
-		FROM tasks 
-		WHERE id = ?
-	`, taskID).Scan(
-		&task.ID,
-		&task.UserID,
-		&solverID,
-		&task.CaptchaType,
-		&task.SiteKey,
-		&task.TargetURL,
-		&captchaResp,
-		&task.CreatedAt,
-	)
+	user, ok := c.Locals("user").(*models.User)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{
+			"status":  "error",
+			"message": "User not found in context",
+		})
+	}
 
+	wait, err := parseWait(c.Query("wait"))
 	if err != nil {
-		if err == sql.ErrNoRows {
+		return c.Status(400).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid wait parameter",
+		})
+	}
+
+	task, err := tasks.Resolve(c.Params("id"))
+	if err == nil && !tasks.CanView(task, user) {
+		err = tasks.ErrTaskNotFound
+	}
+	if err == nil && wait > 0 && !tasks.IsTerminal(task.Status) {
+		// Підписуємось і перечитуємо статус, щоб не пропустити завершення між ними
+		done, cancel := notify.Wait(task.ID)
+		defer cancel()
+
+		task, err = tasks.Get(task.ID)
+		if err == nil && !tasks.IsTerminal(task.Status) {
+			timer := time.NewTimer(wait)
+			select {
+			case <-done:
+			case <-timer.C:
+			}
+			timer.Stop()
+			task, err = tasks.Get(task.ID)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, tasks.ErrTaskNotFound) {
 			return c.Status(404).JSON(fiber.Map{
 				"status":  "error",
 				"message": "Task not found",
@@ -164,12 +398,14 @@ func GetCaptchaResult(c *fiber.Ctx) error {
 		})
 	}
 
-	// Переводимо до *типів
-	if solverID.Valid {
-		task.SolverID = &solverID.Int64
-	}
-	if captchaResp.Valid {
-		task.CaptchaResponse = &captchaResp.String
+	// Прострочений токен не віддаємо: сайт його все одно відхилить
+	if task.Stale {
+		task.RedactStale()
+		return c.JSON(fiber.Map{
+			"status":  "success",
+			"task":    task,
+			"message": "Solution token has expired",
+		})
 	}
 
 	return c.JSON(fiber.Map{
@@ -178,6 +414,165 @@ func GetCaptchaResult(c *fiber.Ctx) error {
 	})
 }
 
+// ReportSolution приймає скаргу клієнта на неправильний розв'язок його завдання
+func ReportSolution(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{
+			"status":  "error",
+			"message": "User not found in context",
+		})
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid request format",
+			})
+		}
+	}
+
+	task, err := tasks.Resolve(c.Params("id"))
+	if err != nil {
+		return c.Status(taskErrorStatus(err)).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Task not found",
+		})
+	}
+
+	report, err := reports.Report(task.ID, user.ID, body.Reason)
+	if err != nil {
+		if reportErrorStatus(err) == 500 {
+			log.Printf("Error reporting solution of task #%d: %v", task.ID, err)
+		}
+		return c.Status(reportErrorStatus(err)).JSON(fiber.Map{
+			"status":  "error",
+			"message": reportErrorMessage(err),
+		})
+	}
+
+	log.Printf("⚠️ Клієнт %s поскаржився на розв'язок завдання #%d (виконавець %d)", user.Username, task.ID, report.SolverID)
+	return c.JSON(fiber.Map{
+		"status":   "success",
+		"report":   report,
+		"refunded": report.Refunded,
+	})
+}
+
+// CancelCaptcha скасовує завдання клієнта. Завдання з черги знімається одразу,
+// а виконавцю, який над ним працює, надсилається task_revoked. Утримані
+// кошти повертаються клієнту автоматично (billing).
+func CancelCaptcha(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*models.User)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{
+			"status":  "error",
+			"message": "User not found in context",
+		})
+	}
+
+	task, err := tasks.Resolve(c.Params("id"))
+	if err == nil {
+		err = tasks.CancelOwned(task.ID, user.ID)
+	}
+	if err != nil {
+		message := "Failed to cancel task"
+		switch {
+		case errors.Is(err, tasks.ErrTaskNotFound):
+			message = "Task not found"
+		case errors.Is(err, tasks.ErrInvalidTransition):
+			message = "Task is already finished"
+		default:
+			log.Println("Error cancelling task:", err)
+		}
+		return c.Status(taskErrorStatus(err)).JSON(fiber.Map{
+			"status":  "error",
+			"message": message,
+		})
+	}
+
+	log.Printf("🚫 Клієнт %s скасував завдання #%d", user.Username, task.ID)
+	if cancelled, err := tasks.Get(task.ID); err == nil {
+		task = cancelled
+	}
+	// Повертається те, що провів хук біллінгу при скасуванні, а не ціна задачі
+	refunded, err := billing.RefundedFor(task.ID)
+	if err != nil {
+		log.Printf("Error loading refund of task #%d: %v", task.ID, err)
+	}
+	return c.JSON(fiber.Map{
+		"status":   "success",
+		"task":     task,
+		"refunded": refunded,
+	})
+}
+
+// reportErrorStatus підбирає HTTP-статус для помилки reports.Report
+func reportErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, tasks.ErrTaskNotFound):
+		return 404
+	case errors.Is(err, reports.ErrNotSolved), errors.Is(err, reports.ErrWindowClosed), errors.Is(err, reports.ErrAlreadyReported):
+		return 409
+	default:
+		return 500
+	}
+}
+
+// reportErrorMessage повертає текст помилки скарги для клієнта. Невідомі
+// помилки (БД тощо) назовні не віддаються, їх потрібно логувати.
+func reportErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, tasks.ErrTaskNotFound):
+		return "Task not found"
+	case errors.Is(err, reports.ErrNotSolved):
+		return "Task is not solved"
+	case errors.Is(err, reports.ErrWindowClosed):
+		return "Report window has closed"
+	case errors.Is(err, reports.ErrAlreadyReported):
+		return "Task already reported"
+	default:
+		return "Internal server error"
+	}
+}
+
+// parseWait розбирає параметр wait: тривалість ("30s") або кількість секунд ("30").
+// Значення обмежується config.MaxResultWait.
+func parseWait(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(raw)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(raw)
+		if convErr != nil {
+			return 0, err
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait < 0 {
+		return 0, errors.New("negative wait")
+	}
+	if wait > config.MaxResultWait {
+		wait = config.MaxResultWait
+	}
+	return wait, nil
+}
+
+// parseMaxWait перевіряє max_wait (у секундах) з запиту клієнта. Нуль
+// означає значення за замовчуванням для типу капчі (tasks.MaxWaitFor).
+func parseMaxWait(seconds int) (time.Duration, error) {
+	maxWait := time.Duration(seconds) * time.Second
+	if seconds < 0 || maxWait > config.TaskMaxWaitLimit {
+		return 0, fmt.Errorf("max_wait must be between 0 and %d seconds (0 uses the default)", int(config.TaskMaxWaitLimit.Seconds()))
+	}
+	return maxWait, nil
+}
+
 // SubmitSolution обробляє відправку розв'язку капчі
 func SubmitSolution(c *fiber.Ctx) error {
 	var solutionData struct {
@@ -226,16 +621,13 @@ func SubmitSolution(c *fiber.Ctx) error {
 		})
 	}
 
-	// Оновлення завдання з розв'язком
-	_, err = config.DB.Exec(
-		"UPDATE tasks SET captcha_response = ?, solver_id = ? WHERE id = ?",
-		solutionData.Solution, user.ID, solutionData.TaskID)
-
+	// Розв'язок приймається лише від виконавця, якому завдання видано через Claim
+	err = tasks.Solve(solutionData.TaskID, user.ID, solutionData.Solution)
 	if err != nil {
 		log.Println("Error saving solution:", err)
-		return c.Status(500).JSON(fiber.Map{
+		return c.Status(taskErrorStatus(err)).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to save solution",
+			"message": "Failed to save solution: " + taskErrorMessage(err),
 		})
 	}
 
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"captcha-solver/internal/billing"
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/reports"
+	"captcha-solver/internal/tasks"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCancelCaptchaReportsReleasedAmount(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client", 1)
+
+	task := &models.CaptchaTask{UserID: client.ID, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	if err := billing.PriceTask(task); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+	// Цена в задаче расходится с удержанием (например, изменена вручную) —
+	// клиенту сообщается то, что реально вернулось на баланс
+	held, _ := billing.Held(client.ID)
+	config.DB.Exec("UPDATE tasks SET price = ? WHERE id = ?", held+1, task.ID)
+
+	app := fiber.New()
+	app.Delete("/api/captcha/:id", asUser(client, CancelCaptcha))
+	resp, err := app.Test(httptest.NewRequest("DELETE", "/api/captcha/"+task.PublicID, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	var body struct {
+		Refunded float64 `json:"refunded"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Refunded != held {
+		t.Fatalf("refunded = %v, want the released hold %v", body.Refunded, held)
+	}
+}
+
+func TestParseMaxWaitBounds(t *testing.T) {
+	limit := int(config.TaskMaxWaitLimit.Seconds())
+	for _, seconds := range []int{0, 1, limit} {
+		if _, err := parseMaxWait(seconds); err != nil {
+			t.Errorf("parseMaxWait(%d): %v", seconds, err)
+		}
+	}
+	for _, seconds := range []int{-1, limit + 1} {
+		if _, err := parseMaxWait(seconds); err == nil {
+			t.Errorf("parseMaxWait(%d) accepted an out-of-range value", seconds)
+		}
+	}
+}
+
+func TestReportErrorMessageHidesInternalErrors(t *testing.T) {
+	internal := errors.New("INSERT INTO reports: database is locked")
+	if got := reportErrorMessage(internal); got != "Internal server error" {
+		t.Fatalf("reportErrorMessage(%v) = %q, want a generic message", internal, got)
+	}
+	if status := reportErrorStatus(internal); status != 500 {
+		t.Fatalf("reportErrorStatus(%v) = %d, want 500", internal, status)
+	}
+
+	if got := reportErrorMessage(reports.ErrWindowClosed); got != "Report window has closed" {
+		t.Fatalf("reportErrorMessage(%v) = %q", reports.ErrWindowClosed, got)
+	}
+}
+
+func TestSubmitSolutionRequiresClaim(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client", 1)
+	worker := createUser(t, "worker", "worker", 0)
+	config.DB.Exec("UPDATE users SET api_key = 'worker-key' WHERE id = ?", worker.ID)
+
+	task := &models.CaptchaTask{UserID: client.ID, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/api/captcha/solution", SubmitSolution)
+	submit := func() int {
+		body := `{"api_key": "worker-key", "task_id": ` + strconv.FormatInt(task.ID, 10) + `, "solution": "token"}`
+		req := httptest.NewRequest("POST", "/api/captcha/solution", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// Задачу из очереди нельзя решить, минуя выдачу
+	if status := submit(); status != 409 {
+		t.Fatalf("status = %d for an unclaimed task, want 409", status)
+	}
+	if got, _ := tasks.Get(task.ID); got.Status != models.StatusPending {
+		t.Fatalf("status = %s, want the task to stay pending", got.Status)
+	}
+
+	if _, err := tasks.Claim(worker.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status := submit(); status != 200 {
+		t.Fatalf("status = %d for a claimed task, want 200", status)
+	}
+}
+
+func TestNewTaskNormalizesCaptchaType(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client", 1)
+
+	task, status, err := newTask(client, &submitRequest{CaptchaType: "ReCAPTCHA", SiteKey: "sitekey", TargetURL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("newTask() = %d, %v", status, err)
+	}
+	if task.CaptchaType != "recaptcha" {
+		t.Fatalf("CaptchaType = %q, want recaptcha", task.CaptchaType)
+	}
+}
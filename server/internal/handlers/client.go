@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"captcha-solver/internal/billing"
 	"captcha-solver/internal/config"
 	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"captcha-solver/internal/webhooks"
+	"errors"
 	"fmt"
+	"log"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -12,7 +17,7 @@ import (
 func ShowClientDashboard(c *fiber.Ctx) error {
 	user := c.Locals("user").(*models.User)
 
-	rows, err := config.DB.Query("SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response FROM tasks WHERE user_id = ?", user.ID)
+	rows, err := config.DB.Query("SELECT id, public_id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response, status, price FROM tasks WHERE user_id = ?", user.ID)
 	if err != nil {
 		return c.Status(500).SendString("Ошибка получения задач")
 	}
@@ -21,24 +26,69 @@ func ShowClientDashboard(c *fiber.Ctx) error {
 	var clientTasks []*models.CaptchaTask
 	for rows.Next() {
 		var task models.CaptchaTask
-		if err := rows.Scan(&task.ID, &task.UserID, &task.SolverID, &task.CaptchaType, &task.SiteKey, &task.TargetURL, &task.CaptchaResponse); err != nil {
+		if err := rows.Scan(&task.ID, &task.PublicID, &task.UserID, &task.SolverID, &task.CaptchaType, &task.SiteKey, &task.TargetURL, &task.CaptchaResponse, &task.Status, &task.Price); err != nil {
 			continue
 		}
 		clientTasks = append(clientTasks, &task)
 	}
 
+	webhookSecret, err := webhooks.Secret(user.ID)
+	if err != nil {
+		log.Printf("Error loading webhook secret for user %d: %v", user.ID, err)
+	}
+	deliveries, err := webhooks.ListForUser(user.ID, 20)
+	if err != nil {
+		log.Printf("Error loading webhook deliveries for user %d: %v", user.ID, err)
+	}
+
+	held, err := billing.Held(user.ID)
+	if err != nil {
+		log.Printf("Error loading held funds for user %d: %v", user.ID, err)
+	}
+	ledger, err := billing.ListForUser(user.ID, 20)
+	if err != nil {
+		log.Printf("Error loading ledger for user %d: %v", user.ID, err)
+	}
+	refunds, err := billing.RefundsByTask(user.ID)
+	if err != nil {
+		log.Printf("Error loading refunds for user %d: %v", user.ID, err)
+	}
+
 	return c.Render("client/dashboard", fiber.Map{
-		"Title": "Личный кабинет",
-		"User":  user,
-		"Tasks": clientTasks,
+		"Title":         "Личный кабинет",
+		"User":          user,
+		"Tasks":         clientTasks,
+		"Held":          held,
+		"Ledger":        ledger,
+		"Refunds":       refunds,
+		"WebhookSecret": webhookSecret,
+		"Deliveries":    deliveries,
 	}, "layout")
 }
 
+// Повторная отправка вебхука по запросу клиента
+func RedeliverWebhook(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	var deliveryID int64
+	if _, err := fmt.Sscan(c.Params("id"), &deliveryID); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid delivery ID"})
+	}
+
+	if err := webhooks.Redeliver(deliveryID, user.ID); err != nil {
+		if errors.Is(err, webhooks.ErrDeliveryNotFound) {
+			return c.Status(404).JSON(fiber.Map{"error": "Delivery not found"})
+		}
+		log.Printf("Error redelivering webhook #%d: %v", deliveryID, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to schedule redelivery"})
+	}
+	return c.JSON(fiber.Map{"status": "scheduled"})
+}
+
 // Получение всех задач для клиента
 func GetTasks(c *fiber.Ctx) error {
 	user := c.Locals("user").(*models.User)
 
-	rows, err := config.DB.Query("SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response FROM tasks WHERE user_id = ?", user.ID)
+	rows, err := config.DB.Query("SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response, status FROM tasks WHERE user_id = ?", user.ID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to retrieve tasks"})
 	}
@@ -47,7 +97,7 @@ func GetTasks(c *fiber.Ctx) error {
 	var tasksList []*models.CaptchaTask
 	for rows.Next() {
 		var task models.CaptchaTask
-		if err := rows.Scan(&task.ID, &task.UserID, &task.SolverID, &task.CaptchaType, &task.SiteKey, &task.TargetURL, &task.CaptchaResponse); err != nil {
+		if err := rows.Scan(&task.ID, &task.UserID, &task.SolverID, &task.CaptchaType, &task.SiteKey, &task.TargetURL, &task.CaptchaResponse, &task.Status); err != nil {
 			continue
 		}
 		tasksList = append(tasksList, &task)
@@ -66,7 +116,7 @@ func GetTask(c *fiber.Ctx) error {
 
 	var task models.CaptchaTask
 	err := config.DB.QueryRow(`
-		SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response,
+		SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response, status,
 		       datetime(created_at, 'localtime') as created_at
 		FROM tasks 
 		WHERE id = ? AND user_id = ?
@@ -78,6 +128,7 @@ func GetTask(c *fiber.Ctx) error {
 		&task.SiteKey,
 		&task.TargetURL,
 		&task.CaptchaResponse,
+		&task.Status,
 		&task.CreatedAt,
 	)
 	if err != nil {
@@ -106,25 +157,13 @@ func SubmitCaptchaSolution(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid request format"})
 	}
 
-	// Verify task exists and belongs to this worker
-	var clientID int64
-	err := config.DB.QueryRow("SELECT user_id FROM tasks WHERE id = ? AND solver_id = ?",
-		solution.TaskID, user.ID).Scan(&clientID)
-
-	if err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Task not found or not assigned to you"})
-	}
-
-	// Update the task with the solution
-	_, err = config.DB.Exec(
-		"UPDATE tasks SET captcha_response = ? WHERE id = ?",
-		solution.Solution, solution.TaskID)
-
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to save solution"})
+	// Verify task belongs to this worker and save the solution
+	if err := tasks.Solve(solution.TaskID, user.ID, solution.Solution); err != nil {
+		if errors.Is(err, tasks.ErrTaskNotFound) || errors.Is(err, tasks.ErrNotAssignee) {
+			return c.Status(404).JSON(fiber.Map{"error": "Task not found or not assigned to you"})
+		}
+		return c.Status(taskErrorStatus(err)).JSON(fiber.Map{"error": "Failed to save solution"})
 	}
 
-	// TODO: update client balance here
-
 	return c.JSON(fiber.Map{"status": "success"})
 }
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"captcha-solver/internal/broker"
+	"captcha-solver/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Health повертає стан залежностей сервера: БД і брокера повідомлень. Поки щось із них
+// недоступне, відповідь — 503, щоб це бачили балансувальник і моніторинг.
+func Health(c *fiber.Ctx) error {
+	state := broker.Default().Status()
+
+	database := fiber.Map{"connected": true}
+	if err := config.DB.Ping(); err != nil {
+		database = fiber.Map{"connected": false, "error": err.Error()}
+	}
+
+	status, code := "ok", 200
+	if !state.Connected || database["connected"] == false {
+		status, code = "degraded", 503
+	}
+	return c.Status(code).JSON(fiber.Map{
+		"status":   status,
+		"broker":   state,
+		"database": database,
+	})
+}
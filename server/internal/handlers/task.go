@@ -1,18 +1,16 @@
 package handlers
 
 import (
+	"captcha-solver/internal/billing"
+	"captcha-solver/internal/broker"
 	"captcha-solver/internal/config"
 	"captcha-solver/internal/models"
-	"captcha-solver/internal/rabbitmq"
-	"context"
-	"database/sql"
-	"encoding/json"
-	"fmt"
+	"captcha-solver/internal/reputation"
+	"captcha-solver/internal/tasks"
+	"errors"
 	"log"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 // Создание задачи через API
@@ -36,104 +34,56 @@ func CreateTask(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Sitekey and target URL are required"})
 	}
 
+	payload.CaptchaType = tasks.NormalizeType(payload.CaptchaType)
 	if payload.CaptchaType == "" {
 		payload.CaptchaType = "hcaptcha" // Default type
 	}
 
-	// Insert task with proper timestamp
-	res, err := config.DB.Exec("INSERT INTO tasks (user_id, captcha_type, sitekey, target_url, created_at) VALUES (?, ?, ?, ?, ?)",
-		user.ID, payload.CaptchaType, payload.SiteKey, payload.TargetURL, time.Now())
-	if err != nil {
-		log.Printf("Database error when creating task: %v", err)
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to create task"})
-	}
-
-	taskID, _ := res.LastInsertId()
 	task := &models.CaptchaTask{
-		ID:          taskID,
 		UserID:      user.ID,
 		CaptchaType: payload.CaptchaType,
 		SiteKey:     payload.SiteKey,
 		TargetURL:   payload.TargetURL,
 	}
-
-	// Send to RabbitMQ
-	taskBytes, err := json.Marshal(task)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to process task"})
+	if err := billing.PriceTask(task); err != nil {
+		log.Printf("Error pricing task: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create task"})
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	err = rabbitmq.RabbitMQChannel.PublishWithContext(ctx,
-		"",               // exchange
-		config.QueueName, // routing key
-		false,            // mandatory
-		false,            // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        taskBytes,
-		})
-	if err != nil {
-		log.Printf("Error publishing to RabbitMQ: %v", err)
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to queue task"})
+	if err := tasks.Create(task); err != nil {
+		if errors.Is(err, billing.ErrInsufficientFunds) {
+			return c.Status(402).JSON(fiber.Map{"error": "Insufficient funds"})
+		}
+		log.Printf("Database error when creating task: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create task"})
 	}
 
+	// Send to the task queue; the task is already saved, so a broker outage
+	// is retried in the background instead of failing the request
+	broker.QueueTasks(task)
+
 	return c.JSON(task)
 }
 
 func ShowResult(c *fiber.Ctx) error {
 	idParam := c.Params("id")
-	var taskID int64
-	if _, err := fmt.Sscan(idParam, &taskID); err != nil {
-		return c.Status(400).SendString("Invalid task ID")
-	}
 
 	// Get the current user
 	currentUser := c.Locals("user").(*models.User)
 
-	// Query to get task with additional user info
-	var task models.CaptchaTask
-	var userID int64
-	err := config.DB.QueryRow(`
-		SELECT t.id, t.user_id, t.solver_id, t.captcha_type, t.sitekey, t.target_url, 
-		       t.captcha_response, t.status, t.error_message, t.attempts,
-		       t.created_at, t.updated_at, t.solved_at
-		FROM tasks t
-		WHERE t.id = ?
-	`, taskID).Scan(
-		&task.ID,
-		&userID,
-		&task.SolverID,
-		&task.CaptchaType,
-		&task.SiteKey,
-		&task.TargetURL,
-		&task.CaptchaResponse,
-		&task.Status,
-		&task.ErrorMessage,
-		&task.Attempts,
-		&task.CreatedAt,
-		&task.UpdatedAt,
-		&task.SolvedAt,
-	)
-
+	task, err := tasks.Resolve(idParam)
 	if err != nil {
-		log.Printf("Error retrieving task %d: %v", taskID, err)
-		if err == sql.ErrNoRows {
+		log.Printf("Error retrieving task %s: %v", idParam, err)
+		if errors.Is(err, tasks.ErrTaskNotFound) {
 			return c.Status(404).SendString("Task not found")
 		}
 		return c.Status(500).SendString("Error retrieving task")
 	}
 
 	// Check access permissions
-	if currentUser.Role != "admin" && currentUser.ID != userID {
+	if currentUser.Role != "admin" && currentUser.ID != task.UserID {
 		return c.Status(403).SendString("Access denied")
 	}
 
-	// Add user info to the task
-	task.UserID = userID
-
 	return c.Render("result", fiber.Map{
 		"Title": "Task Result",
 		"Task":  task,
@@ -142,30 +92,29 @@ func ShowResult(c *fiber.Ctx) error {
 }
 
 func ShowTaskList(c *fiber.Ctx) error {
-	rows, err := config.DB.Query("SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response FROM tasks")
+	rows, err := config.DB.Query("SELECT id, public_id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response, status FROM tasks")
 	if err != nil {
 		return c.Status(500).SendString("Ошибка получения задач")
 	}
 	defer rows.Close()
 
-	var tasks []*models.CaptchaTask
+	var taskList []*models.CaptchaTask
 	for rows.Next() {
 		var task models.CaptchaTask
-		if err := rows.Scan(&task.ID, &task.UserID, &task.SolverID, &task.CaptchaType, &task.SiteKey, &task.TargetURL, &task.CaptchaResponse); err != nil {
+		if err := rows.Scan(&task.ID, &task.PublicID, &task.UserID, &task.SolverID, &task.CaptchaType, &task.SiteKey, &task.TargetURL, &task.CaptchaResponse, &task.Status); err != nil {
 			continue
 		}
-		tasks = append(tasks, &task)
+		taskList = append(taskList, &task)
 	}
 	return c.Render("index", fiber.Map{
 		"User":  c.Locals("user").(*models.User),
-		"Tasks": tasks,
+		"Tasks": taskList,
 	}, "layout")
 }
 
 // Очередь задач для решения (workers)
 func ShowSolveQueue(c *fiber.Ctx) error {
-	var count int
-	err := config.DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE captcha_response IS NULL OR captcha_response = ''").Scan(&count)
+	count, err := tasks.QueueDepth()
 	if err != nil {
 		count = 0
 	}
@@ -177,41 +126,27 @@ func ShowSolveQueue(c *fiber.Ctx) error {
 	}, "layout")
 }
 
-// Страница решения капчи
+// Страница решения капчи; задача ищется по публичному ID и видна только
+// тем, кому её можно показать (исполнителю — назначенная ему)
 func ShowCaptcha(c *fiber.Ctx) error {
-	idParam := c.Params("id")
-	var taskID int64
-	if _, err := fmt.Sscan(idParam, &taskID); err != nil {
-		return c.Status(400).SendString("Неверный ID задачи")
-	}
-
-	var task models.CaptchaTask
-	err := config.DB.QueryRow("SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response FROM tasks WHERE id = ?", taskID).
-		Scan(&task.ID, &task.UserID, &task.SolverID, &task.CaptchaType, &task.SiteKey, &task.TargetURL, &task.CaptchaResponse)
-	if err != nil {
+	user := c.Locals("user").(*models.User)
+	task, err := tasks.Resolve(c.Params("id"))
+	if err != nil || !tasks.CanView(task, user) {
 		return c.Status(404).SendString("Задача не найдена")
 	}
 
 	return c.Render("captcha", fiber.Map{
 		"Title": "Решите капчу",
 		"Task":  task,
-		"User":  c.Locals("user").(*models.User),
+		"User":  user,
 	}, "layout")
 }
 
-// Обработка решения капчи
+// Обработка решения капчи; задача ищется по публичному ID
 func HandleCaptchaSolution(c *fiber.Ctx) error {
-	idParam := c.Params("id")
-	var taskID int64
-	if _, err := fmt.Sscan(idParam, &taskID); err != nil {
-		return c.Status(400).SendString("Неверный ID задачи")
-	}
-
-	var task models.CaptchaTask
-	err := config.DB.QueryRow("SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response FROM tasks WHERE id = ?", taskID).
-		Scan(&task.ID, &task.UserID, &task.SolverID, &task.CaptchaType, &task.SiteKey, &task.TargetURL, &task.CaptchaResponse)
+	task, err := tasks.Resolve(c.Params("id"))
 	if err != nil {
-		return c.Status(404).SendString("Задача не найдена")
+		return c.Status(taskErrorStatus(err)).SendString("Задача не найдена")
 	}
 
 	var captchaResponse string
@@ -225,52 +160,90 @@ func HandleCaptchaSolution(c *fiber.Ctx) error {
 		return c.Status(400).SendString("Необходимо решить капчу")
 	}
 
-	// Получаем пользователя, решающего задачу (worker)
+	// Решение принимается только от исполнителя, которому задача выдана через Claim
 	currentUser := c.Locals("user").(*models.User)
-	_, err = config.DB.Exec("UPDATE tasks SET captcha_response = ?, solver_id = ? WHERE id = ?", captchaResponse, currentUser.ID, taskID)
-	if err != nil {
-		return c.Status(500).SendString("Ошибка обновления задачи")
-	}
-	task.CaptchaResponse = &captchaResponse
-	task.SolverID = &currentUser.ID
-
-	// Отправляем результат в очередь результатов RabbitMQ
-	taskBytes, err := json.Marshal(task)
-	if err == nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		rabbitmq.RabbitMQChannel.PublishWithContext(ctx,
-			"",
-			"captcha_results",
-			false,
-			false,
-			amqp.Publishing{
-				ContentType: "application/json",
-				Body:        taskBytes,
-			})
+	if err := tasks.Solve(task.ID, currentUser.ID, captchaResponse); err != nil {
+		log.Printf("Error solving task %d: %v", task.ID, err)
+		return c.Status(taskErrorStatus(err)).SendString("Ошибка обновления задачи")
 	}
 
 	return c.SendString("Капча успешно решена!")
 }
 
-// API: Получение следующей задачи
+// API: Получение следующей задачи (задача сразу назначается текущему пользователю);
+// ?captcha_types= ограничивает типы капчи
 func GetNextTask(c *fiber.Ctx) error {
-	var task models.CaptchaTask
-	err := config.DB.QueryRow("SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response FROM tasks WHERE (captcha_response IS NULL OR captcha_response = '') LIMIT 1").
-		Scan(&task.ID, &task.UserID, &task.SolverID, &task.CaptchaType, &task.SiteKey, &task.TargetURL, &task.CaptchaResponse)
-	if err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Нет доступных задач"})
+	user := c.Locals("user").(*models.User)
+	if user.Role != "worker" && user.Role != "admin" {
+		return c.Status(403).JSON(fiber.Map{"error": "Только исполнители могут получать задачи"})
 	}
-	return c.JSON(task)
+
+	task, err := tasks.Claim(user.ID, tasks.ParseTypes(c.Query("captcha_types"))...)
+	if err != nil {
+		if errors.Is(err, tasks.ErrNoTasks) {
+			return c.Status(404).JSON(fiber.Map{"error": "Нет доступных задач"})
+		}
+		if errors.Is(err, reputation.ErrSuspended) || errors.Is(err, reputation.ErrThrottled) {
+			return c.Status(taskErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Println("Error claiming task:", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Ошибка получения задачи"})
+	}
+
+	// Исполнителю отдаются только поля, нужные для решения; публичный ID —
+	// чтобы отправить решение через /worker/solve/:id
+	return c.JSON(models.Task{
+		Type:     task.CaptchaType,
+		SiteKey:  task.SiteKey,
+		URL:      task.TargetURL,
+		TaskId:   task.ID,
+		PublicID: task.PublicID,
+	})
 }
 
 // API: Получение количества задач в очереди
 func GetQueueCount(c *fiber.Ctx) error {
-	var count int
-	err := config.DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE captcha_response IS NULL OR captcha_response = ''").Scan(&count)
+	count, err := tasks.QueueDepth()
 	if err != nil {
 		count = 0
 	}
-	return c.JSON(fiber.Map{"count": count})
+	byPriority, err := tasks.QueueDepthByPriority()
+	if err != nil {
+		log.Println("Error fetching queue depth by priority:", err)
+	}
+	return c.JSON(fiber.Map{"count": count, "by_priority": byPriority})
+}
+
+// taskErrorStatus подбирает HTTP-код для ошибок пакета tasks
+func taskErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, tasks.ErrTaskNotFound):
+		return 404
+	case errors.Is(err, tasks.ErrNotAssignee), errors.Is(err, reputation.ErrSuspended):
+		return 403
+	case errors.Is(err, reputation.ErrThrottled):
+		return 429
+	case errors.Is(err, tasks.ErrInvalidTransition):
+		return 409
+	default:
+		return 500
+	}
+}
+
+// taskErrorMessage возвращает текст ошибки операции с задачей для
+// исполнителя или клиента. Неизвестные ошибки (БД и т.п.) наружу не
+// отдаются, их нужно логировать.
+func taskErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, tasks.ErrTaskNotFound):
+		return "Task not found"
+	case errors.Is(err, tasks.ErrNotAssignee):
+		return "Task is not assigned to you"
+	case errors.Is(err, tasks.ErrInvalidTransition):
+		return "Task is no longer assigned or already finished"
+	case errors.Is(err, reputation.ErrSuspended), errors.Is(err, reputation.ErrThrottled):
+		return err.Error()
+	default:
+		return "Internal server error"
+	}
 }
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestTaskErrorMessageHidesInternalErrors(t *testing.T) {
+	internal := errors.New("UPDATE tasks SET status = ?: database is locked")
+	if got := taskErrorMessage(internal); got != "Internal server error" {
+		t.Fatalf("taskErrorMessage(%v) = %q, want a generic message", internal, got)
+	}
+
+	wrapped := fmt.Errorf("solve task #1: %w", tasks.ErrNotAssignee)
+	if got := taskErrorMessage(wrapped); got != "Task is not assigned to you" {
+		t.Fatalf("taskErrorMessage(%v) = %q", wrapped, got)
+	}
+}
+
+func TestGetNextTaskSendsOnlyWhatTheWorkerNeeds(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client", 1)
+	worker := createUser(t, "worker", "worker", 0)
+
+	callback := "https://client.example.com/hook"
+	task := &models.CaptchaTask{UserID: client.ID, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com", CallbackURL: &callback}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/api/next-task", asUser(worker, GetNextTask))
+	app.Post("/worker/solve/:id", asUser(worker, HandleCaptchaSolution))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/next-task", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"callback_url", "user_id", "price", "id"} {
+		if _, ok := body[field]; ok {
+			t.Fatalf("next task exposes %q: %v", field, body)
+		}
+	}
+	if body["public_id"] != task.PublicID {
+		t.Fatalf("public_id = %v, want %s", body["public_id"], task.PublicID)
+	}
+
+	solve := func(id string) int {
+		req := httptest.NewRequest("POST", "/worker/solve/"+id, strings.NewReader("h-captcha-response=token"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	if status := solve(strconv.FormatInt(task.ID, 10)); status != 404 {
+		t.Fatalf("status = %d for a numeric ID, want 404", status)
+	}
+	if status := solve(task.PublicID); status != 200 {
+		t.Fatalf("status = %d for the public ID, want 200", status)
+	}
+}
@@ -2,23 +2,39 @@ package handlers
 
 import (
 	"captcha-solver/internal/config"
+	"captcha-solver/internal/dispatch"
 	"captcha-solver/internal/middleware"
 	"captcha-solver/internal/models"
-	"captcha-solver/internal/rabbitmq"
-	"context"
+	"captcha-solver/internal/notify"
+	"captcha-solver/internal/reports"
+	"captcha-solver/internal/reputation"
+	"captcha-solver/internal/tasks"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
-	"time"
+	"sync"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
-
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// wsConn serializes writes to a WebSocket: besides the read loop, the task
+// dispatcher writes to worker connections from other goroutines
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+}
+
+func (c *wsConn) WriteJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteJSON(v)
+}
+
 // Handle WebSocket connections
-func HandleWebSocket(c *websocket.Conn) {
+func HandleWebSocket(conn *websocket.Conn) {
+	c := &wsConn{Conn: conn}
 	defer c.Close()
 
 	// Read authentication message
@@ -96,6 +112,30 @@ func HandleWebSocket(c *websocket.Conn) {
 		return
 	}
 
+	// Completion events for the user's tasks are pushed to every open session
+	session := notify.NewSession(user.ID, c.WriteJSON)
+	notify.Register(session)
+	defer notify.Unregister(session)
+
+	// Tasks leased over this connection go back to the queue once it closes;
+	// leases taken over REST or other connections are left alone
+	leases := tasks.NewLeases(user.ID)
+	defer leases.Abandon()
+	session.TrackLeases(leases)
+
+	// Workers only get tasks of the captcha types they declared (empty — any)
+	capabilities := tasks.NormalizeTypes(auth.CaptchaTypes)
+
+	// Workers in push mode get tasks as soon as they are free
+	var worker *dispatch.Worker
+	if auth.Push && (user.Role == "worker" || user.Role == "admin") {
+		worker = dispatch.NewWorker(user.ID, user.Username, leases, c.WriteJSON)
+		worker.SetTypes(capabilities)
+		dispatch.Register(worker)
+		defer dispatch.Unregister(worker)
+		session.EnablePush()
+	}
+
 	// Main message loop - process incoming messages
 	for {
 		_, msgBytes, err := c.ReadMessage()
@@ -123,7 +163,42 @@ func HandleWebSocket(c *websocket.Conn) {
 				c.WriteJSON(map[string]string{"status": "error", "message": "Only workers and admins can get tasks"})
 				continue
 			}
-			fetchAndSendTask(c, user)
+			fetchAndSendTask(c, user, capabilities, leases)
+
+		case "set_capabilities":
+			// Worker changes the captcha types it is able to solve
+			if user.Role != "worker" && user.Role != "admin" {
+				c.WriteJSON(map[string]string{"status": "error", "message": "Only workers and admins can set capabilities"})
+				continue
+			}
+			var capsData struct {
+				CaptchaTypes []string `json:"captcha_types"`
+			}
+			if err := json.Unmarshal(msgBytes, &capsData); err != nil {
+				log.Println("❌ Invalid set_capabilities JSON:", err)
+				continue
+			}
+
+			effective, ok, err := tasks.EffectiveTypes(user.ID, capsData.CaptchaTypes)
+			if err != nil {
+				log.Println("Error checking allowed captcha types:", err)
+				c.WriteJSON(map[string]string{"status": "error", "message": "Database error"})
+				continue
+			}
+			capabilities = tasks.NormalizeTypes(capsData.CaptchaTypes)
+			if worker != nil {
+				worker.SetTypes(capabilities)
+				go dispatch.Dispatch()
+			}
+			log.Printf("🧩 Worker %s declared captcha types %v (effective: %v)", user.Username, capabilities, effective)
+
+			reply := map[string]interface{}{"status": "ok", "captcha_types": effective}
+			if !ok {
+				reply["message"] = "None of the declared captcha types are allowed for this worker"
+			}
+			if err := c.WriteJSON(reply); err != nil {
+				log.Println("Error sending capabilities confirmation:", err)
+			}
 
 		case "submit_solution":
 			if user.Role != "worker" && user.Role != "admin" {
@@ -142,22 +217,24 @@ func HandleWebSocket(c *websocket.Conn) {
 				log.Printf("📝 Solution content: %s", solutionData.Solution)
 
 				// Update the task with the solution
-				_, err = config.DB.Exec(
-					"UPDATE tasks SET captcha_response = ? WHERE id = ? AND solver_id = ?",
-					solutionData.Solution, solutionData.TaskId, user.ID)
+				err = tasks.Solve(solutionData.TaskId, user.ID, solutionData.Solution)
 
 				if err != nil {
 					log.Println("Error saving solution:", err)
-					errorMsg := map[string]string{"status": "error", "message": "Failed to save solution"}
+					errorMsg := map[string]string{"status": "error", "message": "Failed to save solution: " + taskErrorMessage(err)}
 					if err := c.WriteJSON(errorMsg); err != nil {
 						log.Println("Error sending error message:", err)
 					}
 				} else {
+					leases.Remove(solutionData.TaskId)
 					// Confirm solution received
 					confirmMsg := map[string]string{"status": "solution_saved"}
 					if err := c.WriteJSON(confirmMsg); err != nil {
 						log.Println("Error sending confirmation:", err)
 					}
+					if worker != nil {
+						dispatch.Ready(worker)
+					}
 				}
 			}
 
@@ -166,93 +243,32 @@ func HandleWebSocket(c *websocket.Conn) {
 				c.WriteJSON(map[string]string{"status": "error", "message": "Only clients and admins can create tasks"})
 				continue
 			}
-			// Client is creating a new task
+			// Client is creating a new task; idempotency_key protects against duplicates on retry
 			var taskData struct {
-				SiteKey     string `json:"sitekey"`
-				TargetURL   string `json:"target_url"`
-				CaptchaType string `json:"captcha_type"`
+				submitRequest
+				IdempotencyKey string `json:"idempotency_key"`
 			}
 			if err := json.Unmarshal(msgBytes, &taskData); err != nil {
 				log.Println("❌ Invalid task JSON:", err)
 				continue
 			}
 
-			if taskData.SiteKey == "" || taskData.TargetURL == "" {
-				errorMsg := map[string]string{"status": "error", "message": "Sitekey and target URL are required"}
-				if err := c.WriteJSON(errorMsg); err != nil {
-					log.Println("Error sending error message:", err)
-				}
-				continue
-			}
-
-			if taskData.CaptchaType == "" {
-				taskData.CaptchaType = "hcaptcha" // Default type
-			}
-
-			// Insert task with proper timestamp
-			res, err := config.DB.Exec("INSERT INTO tasks (user_id, captcha_type, sitekey, target_url, created_at) VALUES (?, ?, ?, ?, ?)",
-				user.ID, taskData.CaptchaType, taskData.SiteKey, taskData.TargetURL, time.Now().Format(time.RFC3339))
-			if err != nil {
-				log.Println("Error creating task:", err)
-				errorMsg := map[string]string{"status": "error", "message": "Failed to create task"}
-				if err := c.WriteJSON(errorMsg); err != nil {
-					log.Println("Error sending error message:", err)
-				}
-				continue
-			}
-
-			taskID, _ := res.LastInsertId()
-			task := &models.CaptchaTask{
-				ID:          taskID,
-				UserID:      user.ID,
-				CaptchaType: taskData.CaptchaType,
-				SiteKey:     taskData.SiteKey,
-				TargetURL:   taskData.TargetURL,
-			}
-
-			// Send to RabbitMQ
-			taskBytes, err := json.Marshal(task)
+			task, replayed, _, err := createTask(&user, &taskData.submitRequest, taskData.IdempotencyKey)
 			if err != nil {
-				log.Println("Error marshaling task:", err)
-				errorMsg := map[string]string{"status": "error", "message": "Failed to process task"}
+				errorMsg := map[string]string{"status": "error", "message": err.Error()}
 				if err := c.WriteJSON(errorMsg); err != nil {
 					log.Println("Error sending error message:", err)
 				}
 				continue
 			}
 
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-
-			err = rabbitmq.RabbitMQChannel.PublishWithContext(ctx,
-				"",               // exchange
-				config.QueueName, // routing key
-				false,            // mandatory
-				false,            // immediate
-				amqp.Publishing{
-					ContentType: "application/json",
-					Body:        taskBytes,
-				})
-			if err != nil {
-				log.Println("Error publishing to RabbitMQ:", err)
-				errorMsg := map[string]string{"status": "error", "message": "Failed to queue task"}
-				if err := c.WriteJSON(errorMsg); err != nil {
-					log.Println("Error sending error message:", err)
-				}
-				continue
-			}
-
-			successMsg := map[string]interface{}{
-				"status": "success",
-				"task":   task,
-			}
-			if err := c.WriteJSON(successMsg); err != nil {
+			if err := c.WriteJSON(submitResponse(task, replayed)); err != nil {
 				log.Println("Error sending success message:", err)
 			}
 
 		case "get_tasks":
 			// Client is requesting all tasks
-			rows, err := config.DB.Query("SELECT id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response FROM tasks WHERE user_id = ?", user.ID)
+			rows, err := config.DB.Query("SELECT id, public_id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response, status FROM tasks WHERE user_id = ?", user.ID)
 			if err != nil {
 				log.Println("Error fetching tasks:", err)
 				errorMsg := map[string]string{"status": "error", "message": "Failed to retrieve tasks"}
@@ -266,7 +282,7 @@ func HandleWebSocket(c *websocket.Conn) {
 			var tasksList []*models.CaptchaTask
 			for rows.Next() {
 				var task models.CaptchaTask
-				if err := rows.Scan(&task.ID, &task.UserID, &task.SolverID, &task.CaptchaType, &task.SiteKey, &task.TargetURL, &task.CaptchaResponse); err != nil {
+				if err := rows.Scan(&task.ID, &task.PublicID, &task.UserID, &task.SolverID, &task.CaptchaType, &task.SiteKey, &task.TargetURL, &task.CaptchaResponse, &task.Status); err != nil {
 					continue
 				}
 				tasksList = append(tasksList, &task)
@@ -280,10 +296,112 @@ func HandleWebSocket(c *websocket.Conn) {
 				log.Println("Error sending success message:", err)
 			}
 
+		case "subscribe":
+			// Subscribe to completion events of individual tasks
+			var subData struct {
+				PublicID  string   `json:"public_id"`
+				PublicIDs []string `json:"public_ids"`
+			}
+			if err := json.Unmarshal(msgBytes, &subData); err != nil {
+				log.Println("❌ Invalid subscribe JSON:", err)
+				continue
+			}
+			if subData.PublicID != "" {
+				subData.PublicIDs = append(subData.PublicIDs, subData.PublicID)
+			}
+			if len(subData.PublicIDs) == 0 {
+				c.WriteJSON(map[string]string{"status": "error", "message": "public_id or public_ids is required"})
+				continue
+			}
+
+			subscribed := []string{}
+			var finished []*models.CaptchaTask
+			for _, publicID := range subData.PublicIDs {
+				task, err := tasks.Resolve(publicID)
+				if err != nil || (user.Role != "admin" && task.UserID != user.ID) {
+					continue
+				}
+				session.Subscribe(task.ID)
+				subscribed = append(subscribed, task.PublicID)
+				if tasks.IsTerminal(task.Status) {
+					finished = append(finished, task)
+				}
+			}
+
+			if err := c.WriteJSON(map[string]interface{}{"status": "subscribed", "public_ids": subscribed}); err != nil {
+				log.Println("Error sending subscribe confirmation:", err)
+			}
+			// Tasks that are already finished are reported right away
+			for _, task := range finished {
+				if err := c.WriteJSON(notify.Message(task)); err != nil {
+					log.Println("Error sending task event:", err)
+				}
+			}
+
+		case "report_bad":
+			// Client reports that a solved token was rejected by the target site
+			var reportData struct {
+				PublicID string `json:"public_id"`
+				Reason   string `json:"reason"`
+			}
+			if err := json.Unmarshal(msgBytes, &reportData); err != nil {
+				log.Println("❌ Invalid report_bad JSON:", err)
+				continue
+			}
+
+			task, err := tasks.Resolve(reportData.PublicID)
+			if err != nil {
+				c.WriteJSON(map[string]string{"status": "error", "message": "Task not found"})
+				continue
+			}
+			report, err := reports.Report(task.ID, user.ID, reportData.Reason)
+			if err != nil {
+				if reportErrorStatus(err) == 500 {
+					log.Printf("Error reporting solution of task #%d: %v", task.ID, err)
+				}
+				c.WriteJSON(map[string]string{"status": "error", "message": reportErrorMessage(err)})
+				continue
+			}
+			log.Printf("⚠️ User %s reported solution of task #%d (worker %d)", user.Username, report.TaskID, report.SolverID)
+			if err := c.WriteJSON(map[string]interface{}{"status": "reported", "report": report}); err != nil {
+				log.Println("Error sending report confirmation:", err)
+			}
+
+		case "cancel_task":
+			// Client withdraws a task it no longer needs
+			var cancelData struct {
+				PublicID string `json:"public_id"`
+			}
+			if err := json.Unmarshal(msgBytes, &cancelData); err != nil {
+				log.Println("❌ Invalid cancel_task JSON:", err)
+				continue
+			}
+
+			task, err := tasks.Resolve(cancelData.PublicID)
+			if err != nil {
+				c.WriteJSON(map[string]string{"status": "error", "message": "Task not found"})
+				continue
+			}
+			if err := tasks.CancelOwned(task.ID, user.ID); err != nil {
+				message := taskErrorMessage(err)
+				if errors.Is(err, tasks.ErrInvalidTransition) {
+					message = "Task is already finished"
+				}
+				c.WriteJSON(map[string]string{"status": "error", "message": message})
+				continue
+			}
+			log.Printf("🚫 User %s cancelled task #%d", user.Username, task.ID)
+			if err := c.WriteJSON(map[string]interface{}{"status": "cancelled", "public_id": task.PublicID}); err != nil {
+				log.Println("Error sending cancel confirmation:", err)
+			}
+
 		case "get_queue_count":
 			// Client is requesting queue count
-			var count int
-			err := config.DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE captcha_response IS NULL OR captcha_response = ''").Scan(&count)
+			count, err := tasks.QueueDepth()
+			var byPriority map[string]int
+			if err == nil {
+				byPriority, err = tasks.QueueDepthByPriority()
+			}
 			if err != nil {
 				log.Println("Error fetching queue count:", err)
 				errorMsg := map[string]string{"status": "error", "message": "Failed to retrieve queue count"}
@@ -294,8 +412,9 @@ func HandleWebSocket(c *websocket.Conn) {
 			}
 
 			successMsg := map[string]interface{}{
-				"status": "success",
-				"count":  count,
+				"status":      "success",
+				"count":       count,
+				"by_priority": byPriority,
 			}
 			if err := c.WriteJSON(successMsg); err != nil {
 				log.Println("Error sending success message:", err)
@@ -312,8 +431,9 @@ func HandleWebSocket(c *websocket.Conn) {
 	}
 }
 
-// Helper function to fetch and send a task
-func fetchAndSendTask(c *websocket.Conn, user models.User) {
+// Helper function to fetch and send a task of one of the given captcha types;
+// the sent task is added to the connection's leases
+func fetchAndSendTask(c *wsConn, user models.User, captchaTypes []string, leases *tasks.Leases) {
 	var taskID int64
 	var siteKey, targetURL, captchaType string
 
@@ -321,13 +441,17 @@ func fetchAndSendTask(c *websocket.Conn, user models.User) {
 	err := config.DB.QueryRow(`
 		SELECT id, captcha_type, sitekey, target_url 
 		FROM tasks 
-		WHERE solver_id = ? AND (captcha_response IS NULL OR captcha_response = '')
+		WHERE solver_id = ? AND status = ? AND (deadline_at IS NULL OR deadline_at > datetime('now'))
 		ORDER BY created_at ASC
 		LIMIT 1
-	`, user.ID).Scan(&taskID, &captchaType, &siteKey, &targetURL)
+	`, user.ID, models.StatusAssigned).Scan(&taskID, &captchaType, &siteKey, &targetURL)
 
 	if err == nil {
-		// Знайдено призначене завдання
+		// Знайдено призначене завдання, продовжуємо його оренду
+		if err := tasks.RenewLease(taskID, user.ID); err != nil {
+			log.Printf("Error renewing lease of task #%d: %v", taskID, err)
+		}
+		leases.Add(taskID)
 		task := models.Task{
 			Type:    captchaType,
 			SiteKey: siteKey,
@@ -347,60 +471,37 @@ func fetchAndSendTask(c *websocket.Conn, user models.User) {
 		return
 	}
 
-	// Якщо немає призначених завдань, шукаємо нове
-	err = config.DB.QueryRow(`
-		SELECT id, captcha_type, sitekey, target_url 
-		FROM tasks 
-		WHERE solver_id IS NULL AND (captcha_response IS NULL OR captcha_response = '')
-		ORDER BY created_at ASC
-		LIMIT 1
-	`).Scan(&taskID, &captchaType, &siteKey, &targetURL)
-
+	// Якщо немає призначених завдань, атомарно забираємо нове
+	claimed, err := tasks.Claim(user.ID, captchaTypes...)
 	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Println("Error fetching task:", err)
-			errorMsg := map[string]string{"status": "error", "message": "Database error"}
-			if err := c.WriteJSON(errorMsg); err != nil {
-				log.Println("Error sending error message:", err)
-			}
-		} else {
+		if errors.Is(err, tasks.ErrNoTasks) {
 			// No tasks available
 			noTaskMsg := map[string]string{"status": "no_tasks"}
 			if err := c.WriteJSON(noTaskMsg); err != nil {
 				log.Println("Error sending no-task message:", err)
 			}
+		} else if errors.Is(err, reputation.ErrSuspended) || errors.Is(err, reputation.ErrThrottled) {
+			// Low reputation: the worker has to wait (throttled) or is blocked (suspended)
+			if err := c.WriteJSON(map[string]string{"status": "error", "message": err.Error()}); err != nil {
+				log.Println("Error sending reputation message:", err)
+			}
+		} else {
+			log.Println("Error claiming task:", err)
+			errorMsg := map[string]string{"status": "error", "message": "Database error"}
+			if err := c.WriteJSON(errorMsg); err != nil {
+				log.Println("Error sending error message:", err)
+			}
 		}
 		return
 	}
-
-	// Assign the task to this worker
-	_, err = config.DB.Exec("UPDATE tasks SET solver_id = ? WHERE id = ? AND (captcha_response IS NULL OR captcha_response = '')", user.ID, taskID)
-	if err != nil {
-		log.Println("Error assigning task to worker:", err)
-		errorMsg := map[string]string{"status": "error", "message": "Failed to assign task"}
-		if err := c.WriteJSON(errorMsg); err != nil {
-			log.Println("Error sending error message:", err)
-		}
-		return
-	}
-
-	// Verify that the task was actually assigned and still needs solving
-	var assigned bool
-	err = config.DB.QueryRow("SELECT 1 FROM tasks WHERE id = ? AND solver_id = ? AND (captcha_response IS NULL OR captcha_response = '')", taskID, user.ID).Scan(&assigned)
-	if err != nil || !assigned {
-		log.Println("Task was already solved or assigned to another worker")
-		noTaskMsg := map[string]string{"status": "no_tasks"}
-		if err := c.WriteJSON(noTaskMsg); err != nil {
-			log.Println("Error sending no-task message:", err)
-		}
-		return
-	}
+	taskID = claimed.ID
+	leases.Add(taskID)
 
 	// Send the task
 	task := models.Task{
-		Type:    captchaType,
-		SiteKey: siteKey,
-		URL:     targetURL,
+		Type:    claimed.CaptchaType,
+		SiteKey: claimed.SiteKey,
+		URL:     claimed.TargetURL,
 		TaskId:  taskID,
 	}
 
@@ -411,7 +512,9 @@ func fetchAndSendTask(c *websocket.Conn, user models.User) {
 	if err := c.WriteJSON(task); err != nil {
 		log.Println("Error sending task over WebSocket:", err)
 		// If failed to send, unassign the task
-		_, _ = config.DB.Exec("UPDATE tasks SET solver_id = NULL WHERE id = ?", taskID)
+		if err := tasks.Release(taskID, user.ID); err != nil {
+			log.Printf("Error releasing task #%d: %v", taskID, err)
+		}
 	} else {
 		log.Printf("Task #%d assigned to worker %s (ID: %d)\n", taskID, user.Username, user.ID)
 	}
@@ -14,6 +14,10 @@ import (
 // AuthRequest for API auth
 type AuthRequest struct {
 	ApiKey string `json:"api_key"`
+	Push   bool   `json:"push"` // исполнитель хочет получать задачи без get_task
+
+	// CaptchaTypes — типы капчи, которые решает исполнитель; пусто — любые
+	CaptchaTypes []string `json:"captcha_types"`
 }
 
 // Middleware API аутентификации – только для клиентов
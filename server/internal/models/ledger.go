@@ -0,0 +1,14 @@
+package models
+
+// LedgerEntry — проводка по счёту пользователя вместе с реквизитами транзакции
+type LedgerEntry struct {
+	ID            int64   `json:"id"`
+	TransactionID int64   `json:"transaction_id"`
+	Kind          string  `json:"kind"`    // task_charge, worker_earning, refund, adjustment
+	Account       string  `json:"account"` // balance, held
+	UserID        *int64  `json:"user_id,omitempty"`
+	TaskID        *int64  `json:"task_id,omitempty"`
+	Amount        float64 `json:"amount"` // положительная сумма — зачисление на счёт
+	Memo          *string `json:"memo,omitempty"`
+	CreatedAt     string  `json:"created_at"`
+}
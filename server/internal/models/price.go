@@ -0,0 +1,17 @@
+package models
+
+// Price — запись прайс-листа для типа капчи, действующая с EffectiveFrom
+type Price struct {
+	ID            int64   `json:"id"`
+	CaptchaType   string  `json:"captcha_type"`
+	ClientPrice   float64 `json:"client_price"`  // списывается с клиента за задачу
+	WorkerPayout  float64 `json:"worker_payout"` // зачисляется исполнителю за решение
+	EffectiveFrom string  `json:"effective_from"`
+	CreatedBy     *int64  `json:"created_by,omitempty"`
+	CreatedAt     string  `json:"created_at"`
+}
+
+// Margin возвращает долю платформы с одной задачи
+func (p *Price) Margin() float64 {
+	return p.ClientPrice - p.WorkerPayout
+}
@@ -0,0 +1,12 @@
+package models
+
+// SolutionReport — жалоба клиента на неверное решение задачи
+type SolutionReport struct {
+	ID        int64   `json:"id"`
+	TaskID    int64   `json:"task_id"`
+	UserID    int64   `json:"user_id"`   // клиент, отправивший жалобу
+	SolverID  int64   `json:"solver_id"` // исполнитель, решивший задачу
+	Reason    *string `json:"reason,omitempty"`
+	Refunded  float64 `json:"refunded"` // сумма, возвращённая клиенту
+	CreatedAt string  `json:"created_at"`
+}
@@ -1,27 +1,69 @@
 package models
 
+import "time"
+
+// Статусы жизненного цикла задачи (колонка tasks.status)
+const (
+	StatusPending   = "pending"
+	StatusAssigned  = "assigned"
+	StatusSolved    = "solved"
+	StatusFailed    = "failed"
+	StatusExpired   = "expired"
+	StatusCancelled = "cancelled"
+)
+
 // Task is the simple task structure for WebSocket communication
 type Task struct {
 	Type     string `json:"type"`
 	SiteKey  string `json:"sitekey"`
 	URL      string `json:"url"`
 	TaskId   int64  `json:"task_id"`
-	Solution string `json:"solution,omitempty"` // For sending solutions back
+	PublicID string `json:"public_id,omitempty"` // для веб-формы решения (/worker/solve/:id)
+	Solution string `json:"solution,omitempty"`  // For sending solutions back
 }
 
 // CaptchaTask описывает задачу по решению капчи
 type CaptchaTask struct {
-	ID              int64   `json:"id"`
-	UserID          int64   `json:"user_id"`             // пользователь, отправивший задачу
-	SolverID        *int64  `json:"solver_id,omitempty"` // пользователь, решивший задачу (если есть)
-	CaptchaType     string  `json:"captcha_type"`
-	SiteKey         string  `json:"sitekey"`
-	TargetURL       string  `json:"target_url"`
-	CaptchaResponse *string `json:"captcha_response,omitempty"`
-	Status          string  `json:"status"`
-	ErrorMessage    *string `json:"error_message,omitempty"`
-	Attempts        int     `json:"attempts"`
-	CreatedAt       string  `json:"created_at"`
-	UpdatedAt       string  `json:"updated_at"`
-	SolvedAt        *string `json:"solved_at,omitempty"`
+	ID              int64    `json:"id"`
+	PublicID        string   `json:"public_id"`           // непоследовательный идентификатор для API
+	UserID          int64    `json:"user_id"`             // пользователь, отправивший задачу
+	SolverID        *int64   `json:"solver_id,omitempty"` // пользователь, решивший задачу (если есть)
+	CaptchaType     string   `json:"captcha_type"`
+	SiteKey         string   `json:"sitekey"`
+	TargetURL       string   `json:"target_url"`
+	CaptchaResponse *string  `json:"captcha_response,omitempty"`
+	Status          string   `json:"status"`
+	ErrorMessage    *string  `json:"error_message,omitempty"`
+	Attempts        int      `json:"attempts"`
+	CreatedAt       string   `json:"created_at"`
+	UpdatedAt       string   `json:"updated_at"`
+	SolvedAt        *string  `json:"solved_at,omitempty"`
+	LeaseExpiresAt  *string  `json:"lease_expires_at,omitempty"` // срок аренды исполнителем
+	CallbackURL     *string  `json:"callback_url,omitempty"`     // куда отправить вебхук о завершении
+	Price           *float64 `json:"price,omitempty"`            // цена для клиента, зафиксированная при создании
+	Payout          *float64 `json:"-"`                          // вознаграждение исполнителю, зафиксированное при создании
+	DeadlineAt      *string  `json:"deadline_at,omitempty"`      // после этого момента нерешённая задача истекает
+	ExpiresAt       *string  `json:"expires_at,omitempty"`       // до этого момента действителен токен решения
+	Stale           bool     `json:"stale,omitempty"`            // токен решения уже недействителен
+	Priority        int      `json:"priority"`                   // уровень приоритета в очереди (0 — low, 1 — normal, 2 — high)
+
+	// MaxWait — сколько задача может ждать решения; задаётся клиентом при
+	// создании, ноль означает значение по умолчанию для типа капчи
+	MaxWait time.Duration `json:"-"`
+}
+
+// RedactStale убирает из задачи недействительный токен, чтобы клиент не
+// тратил на него попытку отправки на целевой сайт
+func (t *CaptchaTask) RedactStale() {
+	if t.Stale {
+		t.CaptchaResponse = nil
+	}
+}
+
+// PriceValue возвращает зафиксированную цену задачи или 0, если её нет
+func (t *CaptchaTask) PriceValue() float64 {
+	if t.Price == nil {
+		return 0
+	}
+	return *t.Price
 }
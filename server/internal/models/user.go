@@ -13,4 +13,7 @@ type User struct {
 	APIKey       string    `json:"api_key,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
 	Balance      float64   `json:"balance"`
+	Tier         string    `json:"tier"`                    // тариф клиента, определяет приоритет его задач
+	ShareWeight  int       `json:"share_weight"`            // доля клиента при разделении очереди между клиентами
+	AllowedTypes string    `json:"allowed_types,omitempty"` // типы капчи, разрешённые исполнителю; пусто — любые
 }
@@ -0,0 +1,17 @@
+package models
+
+// WebhookDelivery — отправка вебхука о завершении задачи на callback_url клиента
+type WebhookDelivery struct {
+	ID             int64   `json:"id"`
+	TaskID         int64   `json:"task_id"`
+	UserID         int64   `json:"user_id"`
+	URL            string  `json:"url"`
+	Event          string  `json:"event"`
+	Status         string  `json:"status"` // pending, delivered, failed
+	Attempts       int     `json:"attempts"`
+	NextAttemptAt  string  `json:"next_attempt_at"`
+	CreatedAt      string  `json:"created_at"`
+	DeliveredAt    *string `json:"delivered_at,omitempty"`
+	LastStatusCode *int    `json:"last_status_code,omitempty"` // код ответа последней попытки
+	LastError      *string `json:"last_error,omitempty"`
+}
@@ -0,0 +1,22 @@
+package models
+
+// WorkerStats — накопленная статистика и рейтинг исполнителя
+type WorkerStats struct {
+	UserID             int64    `json:"user_id"`
+	Solved             int      `json:"solved"`
+	Reported           int      `json:"reported"`  // решения, на которые пожаловались клиенты
+	Abandoned          int      `json:"abandoned"` // брошенные аренды
+	MedianSolveSeconds *float64 `json:"median_solve_seconds,omitempty"`
+	Score              float64  `json:"score"`    // 0–100
+	Standing           string   `json:"standing"` // good, throttled, suspended
+	LastAssignedAt     *string  `json:"last_assigned_at,omitempty"`
+	UpdatedAt          string   `json:"updated_at"`
+}
+
+// MedianSolveValue возвращает медианное время решения в секундах или 0
+func (s *WorkerStats) MedianSolveValue() float64 {
+	if s.MedianSolveSeconds == nil {
+		return 0
+	}
+	return *s.MedianSolveSeconds
+}
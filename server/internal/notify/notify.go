@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/results"
+	"captcha-solver/internal/tasks"
+	"log"
+	"sync"
+)
+
+// Session — открытое WebSocket-соединение пользователя, которому
+// отправляются события о завершении задач
+type Session struct {
+	UserID int64
+
+	send     func(v interface{}) error
+	mu       sync.Mutex
+	explicit map[int64]bool // задачи, на которые сессия подписалась командой subscribe
+	push     bool           // исполнитель в push-режиме, получает task_revoked
+	leases   *tasks.Leases  // задачи, выданные через это соединение
+}
+
+// NewSession создаёт сессию; send должен быть безопасен для вызова из других горутин
+func NewSession(userID int64, send func(v interface{}) error) *Session {
+	return &Session{UserID: userID, send: send, explicit: make(map[int64]bool)}
+}
+
+// Subscribe подписывает сессию на события конкретной задачи
+func (s *Session) Subscribe(taskID int64) {
+	s.mu.Lock()
+	s.explicit[taskID] = true
+	s.mu.Unlock()
+}
+
+// EnablePush включает для сессии уведомления task_revoked обо всех задачах
+// исполнителя: в push-режиме задачи приходят без запроса
+func (s *Session) EnablePush() {
+	s.mu.Lock()
+	s.push = true
+	s.mu.Unlock()
+}
+
+// TrackLeases связывает сессию с задачами, выданными через соединение по
+// get_task: об их отзыве сессия получает task_revoked и в pull-режиме
+func (s *Session) TrackLeases(leases *tasks.Leases) {
+	s.mu.Lock()
+	s.leases = leases
+	s.mu.Unlock()
+}
+
+// holds сообщает, нужно ли сообщить сессии об отзыве задачи
+func (s *Session) holds(taskID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.push || (s.leases != nil && s.leases.Has(taskID))
+}
+
+func (s *Session) wants(taskID, userID int64) bool {
+	if s.UserID == userID {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.explicit[taskID]
+}
+
+var (
+	mu       sync.RWMutex
+	sessions = make(map[*Session]struct{})
+)
+
+// Register добавляет сессию в реестр получателей событий
+func Register(s *Session) {
+	mu.Lock()
+	sessions[s] = struct{}{}
+	mu.Unlock()
+}
+
+// Unregister удаляет сессию из реестра
+func Unregister(s *Session) {
+	mu.Lock()
+	delete(sessions, s)
+	mu.Unlock()
+}
+
+// Start подписывает ожидающие запросы на завершение задач, а рассылку — на
+// события о результатах из очереди результатов
+func Start() {
+	tasks.Subscribe(func(ev tasks.Event) {
+		if tasks.IsTerminal(ev.To) {
+			wake(ev.TaskID)
+		}
+		if ev.Revoked() {
+			go revoke(ev)
+		}
+	})
+	// Рассылка идёт после фиксации обработки события и по порядку; клиент,
+	// который был не на связи, узнаёт итог командой subscribe
+	results.Subscribe(func(ev results.Event) {
+		deliver(ev.TaskID, ev.UserID)
+	})
+}
+
+// EventName возвращает имя события для конечного статуса, например task_solved
+func EventName(status string) string {
+	return "task_" + status
+}
+
+// Message формирует сообщение о завершении задачи; недействительный токен
+// в сообщение не попадает
+func Message(task *models.CaptchaTask) map[string]interface{} {
+	task.RedactStale()
+	return map[string]interface{}{
+		"event": EventName(task.Status),
+		"task":  task,
+	}
+}
+
+// RevokedMessage формирует сообщение исполнителю о том, что задача у него
+// отозвана и окно с капчей нужно закрыть
+func RevokedMessage(taskID int64, status string) map[string]interface{} {
+	return map[string]interface{}{
+		"event":   "task_revoked",
+		"task_id": taskID,
+		"status":  status,
+	}
+}
+
+func deliver(taskID, userID int64) {
+	var recipients []*Session
+	mu.RLock()
+	for s := range sessions {
+		if s.wants(taskID, userID) {
+			recipients = append(recipients, s)
+		}
+	}
+	mu.RUnlock()
+
+	if len(recipients) == 0 {
+		return
+	}
+
+	task, err := tasks.Get(taskID)
+	if err != nil {
+		log.Printf("Error loading task #%d for notification: %v", taskID, err)
+		return
+	}
+
+	msg := Message(task)
+	for _, s := range recipients {
+		if err := s.send(msg); err != nil {
+			log.Printf("Error notifying user %d about task #%d: %v", s.UserID, taskID, err)
+		}
+	}
+}
+
+// revoke сообщает исполнителю, что задача у него отозвана: всем его
+// push-сессиям и сессии, через которую задача была выдана
+func revoke(ev tasks.Event) {
+	var recipients []*Session
+	mu.RLock()
+	for s := range sessions {
+		if s.UserID == *ev.SolverID && s.holds(ev.TaskID) {
+			recipients = append(recipients, s)
+		}
+	}
+	mu.RUnlock()
+
+	msg := RevokedMessage(ev.TaskID, ev.To)
+	for _, s := range recipients {
+		if err := s.send(msg); err != nil {
+			log.Printf("Error notifying worker %d about revoked task #%d: %v", s.UserID, ev.TaskID, err)
+		}
+	}
+}
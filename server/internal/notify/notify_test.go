@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"testing"
+)
+
+func TestRevokeReachesSessionsHoldingTask(t *testing.T) {
+	var pushed, pulled, other []interface{}
+	push := NewSession(7, func(v interface{}) error { pushed = append(pushed, v); return nil })
+	push.EnablePush()
+	pull := NewSession(7, func(v interface{}) error { pulled = append(pulled, v); return nil })
+	leases := tasks.NewLeases(7)
+	leases.Add(1)
+	pull.TrackLeases(leases)
+	idle := NewSession(7, func(v interface{}) error { other = append(other, v); return nil })
+	idle.TrackLeases(tasks.NewLeases(7))
+	for _, s := range []*Session{push, pull, idle} {
+		Register(s)
+		defer Unregister(s)
+	}
+
+	solver := int64(7)
+	revoke(tasks.Event{TaskID: 1, SolverID: &solver, From: models.StatusAssigned, To: models.StatusExpired})
+
+	if len(pushed) != 1 {
+		t.Fatalf("push session got %d messages, want 1", len(pushed))
+	}
+	if len(pulled) != 1 {
+		t.Fatalf("pull session holding the task got %d messages, want 1", len(pulled))
+	}
+	if len(other) != 0 {
+		t.Fatalf("pull session without the task got %v, want nothing", other)
+	}
+}
@@ -0,0 +1,47 @@
+package notify
+
+import "sync"
+
+var (
+	waitersMu sync.Mutex
+	waiters   = make(map[int64]map[chan struct{}]struct{})
+)
+
+// Wait возвращает канал, который закроется, когда задача перейдёт в конечный
+// статус, и функцию отмены ожидания. Подписываться нужно до чтения статуса
+// задачи из БД, чтобы не пропустить завершение между чтением и ожиданием.
+func Wait(taskID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+
+	waitersMu.Lock()
+	if waiters[taskID] == nil {
+		waiters[taskID] = make(map[chan struct{}]struct{})
+	}
+	waiters[taskID][ch] = struct{}{}
+	waitersMu.Unlock()
+
+	cancel := func() {
+		waitersMu.Lock()
+		defer waitersMu.Unlock()
+		if set, ok := waiters[taskID]; ok {
+			if _, ok := set[ch]; ok {
+				delete(set, ch)
+				if len(set) == 0 {
+					delete(waiters, taskID)
+				}
+			}
+		}
+	}
+	return ch, cancel
+}
+
+// wake будит всех, кто ждёт завершения задачи
+func wake(taskID int64) {
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+
+	for ch := range waiters[taskID] {
+		close(ch)
+	}
+	delete(waiters, taskID)
+}
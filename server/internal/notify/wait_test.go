@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitWokenOnCompletion(t *testing.T) {
+	done, cancel := Wait(42)
+	defer cancel()
+
+	go wake(42)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not woken")
+	}
+}
+
+func TestWaitCancelRemovesWaiter(t *testing.T) {
+	_, cancel := Wait(7)
+	cancel()
+
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+	if _, ok := waiters[7]; ok {
+		t.Fatal("cancelled waiter is still registered")
+	}
+}
@@ -0,0 +1,126 @@
+package reports
+
+import (
+	"captcha-solver/internal/billing"
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/reputation"
+	"captcha-solver/internal/tasks"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrNotSolved       = errors.New("task is not solved")
+	ErrWindowClosed    = errors.New("report window has closed")
+	ErrAlreadyReported = errors.New("task already reported")
+)
+
+// Accuracy — статистика точности исполнителя по жалобам клиентов
+type Accuracy struct {
+	SolverID int64
+	Solved   int
+	Reported int
+}
+
+// Rate возвращает долю решений без жалоб в процентах; без решений — 100
+func (a *Accuracy) Rate() float64 {
+	if a.Solved == 0 {
+		return 100
+	}
+	return float64(a.Solved-a.Reported) * 100 / float64(a.Solved)
+}
+
+// Report регистрирует жалобу клиента userID на решение задачи. Жаловаться
+// можно только на свою решённую задачу в течение config.ReportWindow после
+// решения и только один раз. Жалоба записывается на исполнителя и снижает
+// его рейтинг; если включён config.ReportRefund, клиенту в той же
+// транзакции возвращаются деньги.
+func Report(taskID, userID int64, reason string) (*models.SolutionReport, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		owner    int64
+		solverID sql.NullInt64
+		status   string
+		inWindow bool
+	)
+	err = tx.QueryRow(`SELECT user_id, solver_id, status, COALESCE(solved_at >= datetime('now', ?), 0) FROM tasks WHERE id = ?`,
+		fmt.Sprintf("-%d seconds", int(config.ReportWindow.Seconds())), taskID).
+		Scan(&owner, &solverID, &status, &inWindow)
+	if err == sql.ErrNoRows || (err == nil && owner != userID) {
+		return nil, tasks.ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != models.StatusSolved || !solverID.Valid {
+		return nil, ErrNotSolved
+	}
+	if !inWindow {
+		return nil, ErrWindowClosed
+	}
+
+	report := &models.SolutionReport{TaskID: taskID, UserID: userID, SolverID: solverID.Int64}
+	if reason = strings.TrimSpace(reason); reason != "" {
+		report.Reason = &reason
+	}
+
+	if config.ReportRefund {
+		report.Refunded, err = billing.RefundTx(tx, taskID, userID, "reported incorrect solution", &userID)
+		if err != nil && !errors.Is(err, billing.ErrNothingToRefund) {
+			return nil, err
+		}
+	}
+
+	err = tx.QueryRow(`INSERT INTO solution_reports (task_id, user_id, solver_id, reason, refunded)
+		VALUES (?, ?, ?, ?, ?) RETURNING id, created_at`,
+		report.TaskID, report.UserID, report.SolverID, report.Reason, report.Refunded).
+		Scan(&report.ID, &report.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, ErrAlreadyReported
+		}
+		return nil, err
+	}
+
+	if err := reputation.RecordReport(tx, report.SolverID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// AccuracyByWorker возвращает статистику точности всех исполнителей,
+// решивших хотя бы одну задачу
+func AccuracyByWorker() (map[int64]*Accuracy, error) {
+	rows, err := config.DB.Query(`
+		SELECT t.solver_id, COUNT(*), COUNT(r.id)
+		FROM tasks t
+		LEFT JOIN solution_reports r ON r.task_id = t.id
+		WHERE t.status = ? AND t.solver_id IS NOT NULL
+		GROUP BY t.solver_id`, models.StatusSolved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[int64]*Accuracy)
+	for rows.Next() {
+		var a Accuracy
+		if err := rows.Scan(&a.SolverID, &a.Solved, &a.Reported); err != nil {
+			continue
+		}
+		stats[a.SolverID] = &a
+	}
+	return stats, nil
+}
@@ -0,0 +1,97 @@
+package reports
+
+import (
+	"captcha-solver/internal/billing"
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/db"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var startOnce sync.Once
+
+func setupDB(t *testing.T) {
+	t.Helper()
+	config.DBPath = filepath.Join(t.TempDir(), "test.db")
+	db.DB_Connect()
+	t.Cleanup(func() { config.DB.Close() })
+	startOnce.Do(billing.Start)
+}
+
+func createUser(t *testing.T, username, role string) int64 {
+	t.Helper()
+	res, err := config.DB.Exec("INSERT INTO users (username, password_hash, role) VALUES (?, '', ?)", username, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	if err := billing.Adjust(id, 1, "top up", 1); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func solvedTask(t *testing.T, clientID, workerID int64) int64 {
+	t.Helper()
+	task := &models.CaptchaTask{UserID: clientID, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Assign(task.ID, workerID); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Solve(task.ID, workerID, "token"); err != nil {
+		t.Fatal(err)
+	}
+	return task.ID
+}
+
+func TestReport(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client")
+	other := createUser(t, "other", "client")
+	worker := createUser(t, "worker", "worker")
+
+	reported := solvedTask(t, client, worker)
+	solvedTask(t, client, worker)
+
+	if _, err := Report(reported, other, ""); !errors.Is(err, tasks.ErrTaskNotFound) {
+		t.Fatalf("Report() by another client: err = %v, want ErrTaskNotFound", err)
+	}
+
+	report, err := Report(reported, client, "token rejected")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SolverID != worker || report.Refunded != config.TaskPrice {
+		t.Fatalf("report = %+v, want solver %d and refund %v", report, worker, config.TaskPrice)
+	}
+	if _, err := Report(reported, client, ""); !errors.Is(err, ErrAlreadyReported) {
+		t.Fatalf("second Report() err = %v, want ErrAlreadyReported", err)
+	}
+
+	stats, err := AccuracyByWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := stats[worker]; a == nil || a.Solved != 2 || a.Reported != 1 || a.Rate() != 50 {
+		t.Fatalf("accuracy = %+v, want 1 of 2 reported", a)
+	}
+}
+
+func TestReportWindow(t *testing.T) {
+	setupDB(t)
+	client := createUser(t, "client", "client")
+	worker := createUser(t, "worker", "worker")
+
+	taskID := solvedTask(t, client, worker)
+	config.DB.Exec("UPDATE tasks SET solved_at = datetime('now', '-1 day') WHERE id = ?", taskID)
+
+	if _, err := Report(taskID, client, ""); !errors.Is(err, ErrWindowClosed) {
+		t.Fatalf("Report() after the window: err = %v, want ErrWindowClosed", err)
+	}
+}
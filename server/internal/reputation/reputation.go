@@ -0,0 +1,297 @@
+package reputation
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+)
+
+// Положение исполнителя по рейтингу
+const (
+	StandingGood      = "good"
+	StandingThrottled = "throttled" // не больше одной задачи за config.ReputationThrottleInterval
+	StandingSuspended = "suspended" // задачи не выдаются
+)
+
+// sampleSize — сколько последних времён решения учитывается в медиане
+const sampleSize = 100
+
+var (
+	ErrSuspended = errors.New("worker is suspended due to low reputation")
+	ErrThrottled = errors.New("worker is throttled due to low reputation")
+)
+
+// Start подключает рейтинг к жизненному циклу задач. Статистика обновляется
+// в транзакции перехода, а назначение задачи исполнителю с низким рейтингом
+// отклоняется (ErrSuspended, ErrThrottled) — это действует на все пути
+// выдачи: tasks.Claim, push-раздачу и ручное назначение.
+func Start() {
+	tasks.SubscribeTx(func(tx *sql.Tx, ev tasks.Event) error {
+		if ev.SolverID == nil {
+			return nil
+		}
+		switch {
+		case ev.To == models.StatusAssigned:
+			return admit(tx, *ev.SolverID)
+		case ev.To == models.StatusSolved:
+			return recordSolve(tx, *ev.SolverID, ev.TaskID)
+		case ev.Abandoned:
+			return recordAbandon(tx, *ev.SolverID)
+		}
+		return nil
+	})
+}
+
+// Score вычисляет рейтинг 0–100 как произведение точности (доля решений без
+// жалоб), надёжности (доля задач, доведённых до решения) и скорости
+// (относительно config.ReputationTargetSolveTime). Точность и надёжность
+// сглажены, чтобы у новичка рейтинг не был ни нулевым, ни идеальным.
+func Score(solved, reported, abandoned int, medianSolveSeconds *float64) float64 {
+	accuracy := float64(solved-reported+1) / float64(solved+2)
+	reliability := float64(solved+1) / float64(solved+abandoned+2)
+
+	speed := 1.0
+	if medianSolveSeconds != nil && *medianSolveSeconds > 0 {
+		speed = math.Min(1, config.ReputationTargetSolveTime.Seconds()/(*medianSolveSeconds))
+	}
+
+	score := 100 * accuracy * reliability * (0.5 + 0.5*speed)
+	return math.Round(score*10) / 10
+}
+
+// StandingFor определяет положение исполнителя. Пока у него меньше
+// config.ReputationMinTasks задач, ограничения не применяются.
+func StandingFor(solved, abandoned int, score float64) string {
+	switch {
+	case solved+abandoned < config.ReputationMinTasks:
+		return StandingGood
+	case score < config.ReputationSuspendScore:
+		return StandingSuspended
+	case score < config.ReputationThrottleScore:
+		return StandingThrottled
+	default:
+		return StandingGood
+	}
+}
+
+// RecordReport засчитывает исполнителю жалобу на решение в транзакции вызывающего
+func RecordReport(tx *sql.Tx, solverID int64) error {
+	if err := ensure(tx, solverID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE worker_stats SET reported = reported + 1 WHERE user_id = ?", solverID); err != nil {
+		return err
+	}
+	return refresh(tx, solverID)
+}
+
+// Reset обнуляет статистику исполнителя, например после разбора его
+// отстранения администратором. Пока исполнитель снова не наберёт
+// config.ReputationMinTasks задач, ограничения к нему не применяются.
+func Reset(userID int64) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := ensure(tx, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM worker_solve_times WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE worker_stats SET solved = 0, reported = 0, abandoned = 0, median_solve_seconds = NULL,
+		score = ?, updated_at = datetime('now') WHERE user_id = ?`, Score(0, 0, 0, nil), userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// All возвращает статистику всех исполнителей по ID пользователя
+func All() (map[int64]*models.WorkerStats, error) {
+	rows, err := config.DB.Query(`SELECT user_id, solved, reported, abandoned, median_solve_seconds, score, last_assigned_at, updated_at
+		FROM worker_stats`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[int64]*models.WorkerStats)
+	for rows.Next() {
+		var s models.WorkerStats
+		if err := rows.Scan(&s.UserID, &s.Solved, &s.Reported, &s.Abandoned, &s.MedianSolveSeconds, &s.Score, &s.LastAssignedAt, &s.UpdatedAt); err != nil {
+			continue
+		}
+		s.Standing = StandingFor(s.Solved, s.Abandoned, s.Score)
+		stats[s.UserID] = &s
+	}
+	return stats, nil
+}
+
+// Backfill заполняет статистику по уже решённым задачам и жалобам, если
+// она ещё пуста (первый запуск после появления рейтинга). Брошенные аренды
+// в прошлом не записывались и начинают учитываться с этого момента.
+func Backfill() error {
+	var count int
+	if err := config.DB.QueryRow("SELECT COUNT(*) FROM worker_stats").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO worker_stats (user_id, solved, reported)
+		SELECT t.solver_id, COUNT(*), COUNT(r.id)
+		FROM tasks t
+		JOIN users u ON u.id = t.solver_id
+		LEFT JOIN solution_reports r ON r.task_id = t.id
+		WHERE t.status = ?
+		GROUP BY t.solver_id`, models.StatusSolved)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	rows, err := tx.Query("SELECT user_id FROM worker_stats")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := refresh(tx, id); err != nil {
+			return err
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("📊 Reputation backfilled for %d workers", len(ids))
+	}
+	return tx.Commit()
+}
+
+// admit проверяет, можно ли назначить задачу исполнителю, и запоминает время назначения
+func admit(tx *sql.Tx, userID int64) error {
+	var role string
+	if err := tx.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err := ensure(tx, userID); err != nil {
+		return err
+	}
+
+	if role != "admin" {
+		var (
+			solved, abandoned int
+			score             float64
+			recent            bool
+		)
+		err := tx.QueryRow(`SELECT solved, abandoned, score, COALESCE(last_assigned_at > datetime('now', ?), 0)
+			FROM worker_stats WHERE user_id = ?`,
+			fmt.Sprintf("-%d seconds", int(config.ReputationThrottleInterval.Seconds())), userID).
+			Scan(&solved, &abandoned, &score, &recent)
+		if err != nil {
+			return err
+		}
+		switch StandingFor(solved, abandoned, score) {
+		case StandingSuspended:
+			return ErrSuspended
+		case StandingThrottled:
+			if recent {
+				return ErrThrottled
+			}
+		}
+	}
+
+	_, err := tx.Exec("UPDATE worker_stats SET last_assigned_at = datetime('now') WHERE user_id = ?", userID)
+	return err
+}
+
+// recordSolve засчитывает решение и время от назначения до решения
+func recordSolve(tx *sql.Tx, userID, taskID int64) error {
+	if err := ensure(tx, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO worker_solve_times (user_id, seconds)
+		SELECT ?, MAX(0, (julianday(solved_at) - julianday(assigned_at)) * 86400)
+		FROM tasks WHERE id = ? AND assigned_at IS NOT NULL AND solved_at IS NOT NULL`, userID, taskID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM worker_solve_times WHERE user_id = ? AND id NOT IN (
+		SELECT id FROM worker_solve_times WHERE user_id = ? ORDER BY id DESC LIMIT ?)`, userID, userID, sampleSize)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE worker_stats SET solved = solved + 1 WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return refresh(tx, userID)
+}
+
+// recordAbandon засчитывает брошенную аренду
+func recordAbandon(tx *sql.Tx, userID int64) error {
+	if err := ensure(tx, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE worker_stats SET abandoned = abandoned + 1 WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return refresh(tx, userID)
+}
+
+// ensure создаёт строку статистики исполнителя с начальным рейтингом
+func ensure(tx *sql.Tx, userID int64) error {
+	_, err := tx.Exec("INSERT OR IGNORE INTO worker_stats (user_id, score) VALUES (?, ?)", userID, Score(0, 0, 0, nil))
+	return err
+}
+
+// refresh пересчитывает медиану времени решения и рейтинг исполнителя
+func refresh(tx *sql.Tx, userID int64) error {
+	var samples int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM worker_solve_times WHERE user_id = ?", userID).Scan(&samples); err != nil {
+		return err
+	}
+
+	var median *float64
+	if samples > 0 {
+		// Для чётного числа замеров — среднее двух центральных
+		var m float64
+		err := tx.QueryRow(`SELECT AVG(seconds) FROM (
+			SELECT seconds FROM worker_solve_times WHERE user_id = ? ORDER BY seconds LIMIT ? OFFSET ?)`,
+			userID, 2-samples%2, (samples-1)/2).Scan(&m)
+		if err != nil {
+			return err
+		}
+		median = &m
+	}
+
+	var solved, reported, abandoned int
+	if err := tx.QueryRow("SELECT solved, reported, abandoned FROM worker_stats WHERE user_id = ?", userID).
+		Scan(&solved, &reported, &abandoned); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec("UPDATE worker_stats SET median_solve_seconds = ?, score = ?, updated_at = datetime('now') WHERE user_id = ?",
+		median, Score(solved, reported, abandoned, median), userID)
+	return err
+}
@@ -0,0 +1,148 @@
+package reputation
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/db"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var startOnce sync.Once
+
+func setupDB(t *testing.T) {
+	t.Helper()
+	config.DBPath = filepath.Join(t.TempDir(), "test.db")
+	db.DB_Connect()
+	t.Cleanup(func() { config.DB.Close() })
+	startOnce.Do(Start)
+}
+
+func createWorker(t *testing.T, username string) int64 {
+	t.Helper()
+	res, err := config.DB.Exec("INSERT INTO users (username, password_hash, role) VALUES (?, '', 'worker')", username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func createTask(t *testing.T) int64 {
+	t.Helper()
+	task := &models.CaptchaTask{UserID: 1, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+	return task.ID
+}
+
+func TestScore(t *testing.T) {
+	good := Score(100, 0, 0, nil)
+	reported := Score(100, 40, 0, nil)
+	abandoning := Score(100, 0, 100, nil)
+	slow := 10 * config.ReputationTargetSolveTime.Seconds()
+	sluggish := Score(100, 0, 0, &slow)
+
+	if good < 95 {
+		t.Fatalf("Score() of a flawless worker = %v, want close to 100", good)
+	}
+	for name, score := range map[string]float64{"reported": reported, "abandoning": abandoning, "slow": sluggish} {
+		if score >= good {
+			t.Errorf("%s worker scored %v, want below %v", name, score, good)
+		}
+	}
+}
+
+func TestStatsFollowEvents(t *testing.T) {
+	setupDB(t)
+	worker := createWorker(t, "worker")
+
+	solved := createTask(t)
+	if err := tasks.Assign(solved, worker); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Solve(solved, worker, "token"); err != nil {
+		t.Fatal(err)
+	}
+	abandoned := createTask(t)
+	if err := tasks.Assign(abandoned, worker); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Abandon(abandoned, worker); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := stats[worker]
+	if s == nil || s.Solved != 1 || s.Abandoned != 1 || s.MedianSolveSeconds == nil {
+		t.Fatalf("stats = %+v, want 1 solved with a solve time and 1 abandoned", s)
+	}
+	if s.Score != Score(1, 0, 1, s.MedianSolveSeconds) {
+		t.Fatalf("score = %v, want %v", s.Score, Score(1, 0, 1, s.MedianSolveSeconds))
+	}
+}
+
+func TestLowReputationLimitsClaims(t *testing.T) {
+	setupDB(t)
+	suspended := createWorker(t, "suspended")
+	throttled := createWorker(t, "throttled")
+
+	// Исполнитель, бросивший почти все задачи, отстраняется
+	config.DB.Exec("INSERT INTO worker_stats (user_id, solved, abandoned, score) VALUES (?, 1, 30, ?)",
+		suspended, Score(1, 0, 30, nil))
+	// Исполнитель с частыми жалобами получает задачи не чаще раза в интервал
+	config.DB.Exec("INSERT INTO worker_stats (user_id, solved, reported, score) VALUES (?, 20, 12, ?)",
+		throttled, Score(20, 12, 0, nil))
+
+	createTask(t)
+	createTask(t)
+
+	if _, err := tasks.Claim(suspended); !errors.Is(err, ErrSuspended) {
+		t.Fatalf("Claim() by suspended worker: err = %v, want ErrSuspended", err)
+	}
+	if _, err := tasks.Claim(throttled); err != nil {
+		t.Fatalf("first Claim() by throttled worker: %v", err)
+	}
+	if _, err := tasks.Claim(throttled); !errors.Is(err, ErrThrottled) {
+		t.Fatalf("second Claim() by throttled worker: err = %v, want ErrThrottled", err)
+	}
+
+	var pending int
+	config.DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE status = ?", models.StatusPending).Scan(&pending)
+	if pending != 1 {
+		t.Fatalf("%d pending tasks, want 1 left in the queue", pending)
+	}
+}
+
+func TestResetLiftsSuspension(t *testing.T) {
+	setupDB(t)
+	worker := createWorker(t, "worker")
+	config.DB.Exec("INSERT INTO worker_stats (user_id, solved, abandoned, score) VALUES (?, 1, 30, ?)",
+		worker, Score(1, 0, 30, nil))
+	createTask(t)
+
+	if _, err := tasks.Claim(worker); !errors.Is(err, ErrSuspended) {
+		t.Fatalf("Claim() before reset: err = %v, want ErrSuspended", err)
+	}
+	if err := Reset(worker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tasks.Claim(worker); err != nil {
+		t.Fatalf("Claim() after reset: %v", err)
+	}
+
+	stats, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := stats[worker]; s.Solved != 0 || s.Abandoned != 0 || s.Standing != StandingGood {
+		t.Fatalf("stats after reset = %+v", s)
+	}
+}
@@ -0,0 +1,100 @@
+package results
+
+import (
+	"captcha-solver/internal/broker"
+	"captcha-solver/internal/config"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Сколько событий публикуется за один проход
+const publishBatch = 100
+
+// RunPublisher публикует накопленные в outbox события в обменник результатов.
+// Пока брокер недоступен, события копятся в БД и уходят после переподключения.
+func RunPublisher() {
+	ticker := time.NewTicker(config.ResultsPublishInterval)
+	defer ticker.Stop()
+	lastPurge := time.Time{}
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-kick:
+		}
+		publishPending()
+
+		if time.Since(lastPurge) >= time.Hour {
+			purge()
+			lastPurge = time.Now()
+		}
+	}
+}
+
+type pending struct {
+	id         int64
+	routingKey string
+	payload    string
+}
+
+// publishPending публикует неопубликованные события по порядку и отмечает
+// каждое опубликованным после подтверждения брокера
+func publishPending() {
+	for {
+		batch, err := loadPending()
+		if err != nil {
+			log.Println("Ошибка загрузки событий о результатах:", err)
+			return
+		}
+		for _, ev := range batch {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := broker.Default().Publish(ctx, config.ResultsExchange, ev.routingKey, broker.Message{Body: []byte(ev.payload)})
+			cancel()
+			if err != nil {
+				if !errors.Is(err, broker.ErrNotConnected) {
+					log.Printf("Ошибка публикации события о результате #%d: %v", ev.id, err)
+				}
+				return
+			}
+			if _, err := config.DB.Exec("UPDATE result_events SET published_at = datetime('now') WHERE id = ?", ev.id); err != nil {
+				log.Printf("Ошибка отметки события о результате #%d опубликованным: %v", ev.id, err)
+				return
+			}
+		}
+		if len(batch) < publishBatch {
+			return
+		}
+	}
+}
+
+func loadPending() ([]pending, error) {
+	rows, err := config.DB.Query(`SELECT id, routing_key, payload FROM result_events
+		WHERE published_at IS NULL ORDER BY id LIMIT ?`, publishBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []pending
+	for rows.Next() {
+		var ev pending
+		if err := rows.Scan(&ev.id, &ev.routingKey, &ev.payload); err != nil {
+			return nil, err
+		}
+		batch = append(batch, ev)
+	}
+	return batch, rows.Err()
+}
+
+// purge удаляет обработанные события старше config.ResultEventsRetention
+func purge() {
+	modifier := fmt.Sprintf("-%d seconds", int(config.ResultEventsRetention.Seconds()))
+	_, err := config.DB.Exec(`DELETE FROM result_events
+		WHERE published_at IS NOT NULL AND consumed_at IS NOT NULL AND created_at < datetime('now', ?)`, modifier)
+	if err != nil {
+		log.Println("Ошибка удаления старых событий о результатах:", err)
+	}
+}
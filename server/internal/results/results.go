@@ -0,0 +1,175 @@
+package results
+
+import (
+	"captcha-solver/internal/broker"
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Event — событие о результате задачи: она решена или завершилась без
+// решения (failed, expired, cancelled). События публикуются в обменник
+// config.ResultsExchange с ключом task.<status>; сервер читает их из своей
+// очереди и раздаёт подписчикам (вебхуки, WebSocket, статистика), а внешние
+// системы могут привязать к обменнику свои очереди.
+type Event struct {
+	ID          int64   `json:"id"`
+	Event       string  `json:"event"` // task_solved, task_failed, ...
+	TaskID      int64   `json:"task_id"`
+	PublicID    string  `json:"public_id"`
+	UserID      int64   `json:"user_id"`
+	SolverID    *int64  `json:"solver_id,omitempty"`
+	CaptchaType string  `json:"captcha_type"`
+	Status      string  `json:"status"`
+	From        string  `json:"from"`
+	Error       *string `json:"error,omitempty"`
+	OccurredAt  string  `json:"occurred_at"`
+}
+
+// RoutingKey возвращает ключ маршрутизации события в обменнике результатов
+func RoutingKey(status string) string {
+	return "task." + status
+}
+
+var (
+	mu            sync.RWMutex
+	txSubscribers []func(tx *sql.Tx, ev Event) error
+	subscribers   []func(Event)
+
+	// kick будит публикатор сразу после появления нового события
+	kick = make(chan struct{}, 1)
+)
+
+// SubscribeTx регистрирует обработчик, который выполняется в транзакции,
+// отмечающей событие обработанным. Если обработчик вернёт ошибку, транзакция
+// откатывается, а сообщение возвращается в очередь и будет обработано снова;
+// так ничего не теряется и не учитывается дважды. Сюда относится всё, что
+// пишется в БД (статистика, доставки вебхуков).
+func SubscribeTx(fn func(tx *sql.Tx, ev Event) error) {
+	mu.Lock()
+	txSubscribers = append(txSubscribers, fn)
+	mu.Unlock()
+}
+
+// Subscribe регистрирует обработчик, который вызывается после фиксации
+// обработки события, например для уведомлений по WebSocket. Обработчики
+// вызываются последовательно из потребителя очереди, поэтому долгую работу
+// следует выносить в горутину.
+func Subscribe(fn func(Event)) {
+	mu.Lock()
+	subscribers = append(subscribers, fn)
+	mu.Unlock()
+}
+
+// Start записывает событие о каждом завершении задачи в той же транзакции,
+// что и смену статуса (таблица result_events служит outbox), и запускает
+// потребителя очереди результатов
+func Start() {
+	tasks.SubscribeTx(func(tx *sql.Tx, ev tasks.Event) error {
+		if !tasks.IsTerminal(ev.To) {
+			return nil
+		}
+		return record(tx, ev)
+	})
+	tasks.Subscribe(func(ev tasks.Event) {
+		if tasks.IsTerminal(ev.To) {
+			select {
+			case kick <- struct{}{}:
+			default:
+			}
+		}
+	})
+	SubscribeTx(countResult)
+	go broker.ConsumeResults(handle)
+}
+
+// record сохраняет событие о результате в outbox
+func record(tx *sql.Tx, ev tasks.Event) error {
+	result := Event{
+		Event:      "task_" + ev.To,
+		TaskID:     ev.TaskID,
+		UserID:     ev.UserID,
+		SolverID:   ev.SolverID,
+		Status:     ev.To,
+		From:       ev.From,
+		OccurredAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	err := tx.QueryRow("SELECT public_id, captcha_type, error_message FROM tasks WHERE id = ?", ev.TaskID).
+		Scan(&result.PublicID, &result.CaptchaType, &result.Error)
+	if err != nil {
+		return err
+	}
+	if ev.To == models.StatusSolved {
+		result.Error = nil
+	}
+
+	res, err := tx.Exec("INSERT INTO result_events (task_id, routing_key) VALUES (?, ?)", ev.TaskID, RoutingKey(ev.To))
+	if err != nil {
+		return err
+	}
+	if result.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE result_events SET payload = ? WHERE id = ?", string(payload), result.ID)
+	return err
+}
+
+// handle обрабатывает сообщение из очереди результатов. Событие отмечается
+// обработанным в одной транзакции с обработчиками SubscribeTx, поэтому при
+// ошибке оно будет обработано заново, а повторная доставка уже обработанного
+// события ничего не делает. Обработчики Subscribe вызываются после фиксации.
+func handle(body []byte) error {
+	var ev Event
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return fmt.Errorf("%w: %v", broker.ErrMalformed, err)
+	}
+
+	first, err := consume(ev)
+	if err != nil || !first {
+		return err
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, fn := range subscribers {
+		fn(ev)
+	}
+	return nil
+}
+
+// consume отмечает событие обработанным и выполняет обработчики SubscribeTx.
+// Возвращает false, если событие уже было обработано.
+func consume(ev Event) (bool, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE result_events SET consumed_at = datetime('now') WHERE id = ? AND consumed_at IS NULL", ev.ID)
+	if err != nil {
+		return false, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return false, nil
+	}
+
+	mu.RLock()
+	fns := txSubscribers
+	mu.RUnlock()
+	for _, fn := range fns {
+		if err := fn(tx, ev); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,216 @@
+package results
+
+import (
+	"captcha-solver/internal/broker"
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/db"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/tasks"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	startOnce sync.Once
+	errTest   = errors.New("test failure")
+)
+
+func setupDB(t *testing.T) {
+	t.Helper()
+	config.DBPath = filepath.Join(t.TempDir(), "test.db")
+	db.DB_Connect()
+	t.Cleanup(func() { config.DB.Close() })
+	startOnce.Do(func() {
+		broker.Init(broker.NewMemory())
+		Start()
+	})
+}
+
+func newTask(t *testing.T) *models.CaptchaTask {
+	t.Helper()
+	task := &models.CaptchaTask{UserID: 1, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+// outbox возвращает ключи и тела событий в порядке записи
+func outbox(t *testing.T) ([]string, [][]byte) {
+	t.Helper()
+	rows, err := config.DB.Query("SELECT routing_key, payload FROM result_events ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	var payloads [][]byte
+	for rows.Next() {
+		var key, payload string
+		if err := rows.Scan(&key, &payload); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+		payloads = append(payloads, []byte(payload))
+	}
+	return keys, payloads
+}
+
+func TestTerminalTransitionsRecordResultEvents(t *testing.T) {
+	setupDB(t)
+	solved, failed := newTask(t), newTask(t)
+	newTask(t) // не завершена — события нет
+
+	if err := tasks.Assign(solved.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Solve(solved.ID, 2, "token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Assign(failed.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Fail(failed.ID, "unsolvable"); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, payloads := outbox(t)
+	if len(keys) != 2 || keys[0] != "task.solved" || keys[1] != "task.failed" {
+		t.Fatalf("routing keys = %v, want [task.solved task.failed]", keys)
+	}
+
+	var ev Event
+	if err := json.Unmarshal(payloads[1], &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.ID == 0 || ev.Event != "task_failed" || ev.TaskID != failed.ID || ev.PublicID == "" ||
+		ev.CaptchaType != "hcaptcha" || ev.From != models.StatusAssigned || ev.Error == nil || *ev.Error != "unsolvable" {
+		t.Fatalf("failed event = %+v", ev)
+	}
+	if ev.SolverID == nil || *ev.SolverID != 2 {
+		t.Fatalf("failed event solver = %v, want 2", ev.SolverID)
+	}
+}
+
+func TestHandleDeliversEachEventOnce(t *testing.T) {
+	setupDB(t)
+	task := newTask(t)
+	if err := tasks.Assign(task.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Solve(task.ID, 2, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	var delivered []int64
+	Subscribe(func(ev Event) {
+		if ev.TaskID == task.ID {
+			delivered = append(delivered, ev.TaskID)
+		}
+	})
+
+	_, payloads := outbox(t)
+	// Брокер может доставить сообщение повторно
+	for i := 0; i < 2; i++ {
+		if err := handle(payloads[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(delivered) != 1 {
+		t.Fatalf("event delivered %d times, want once", len(delivered))
+	}
+
+	stats, err := StatsByType(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].CaptchaType != "hcaptcha" || stats[0].Solved != 1 || stats[0].Total() != 1 {
+		t.Fatalf("stats = %+v, want one solved hcaptcha", stats)
+	}
+
+	if err := handle([]byte("not json")); !errors.Is(err, broker.ErrMalformed) {
+		t.Fatalf("handle() of a malformed message = %v, want ErrMalformed", err)
+	}
+}
+
+func TestPublishedEventsReachSubscribers(t *testing.T) {
+	setupDB(t)
+	task := newTask(t)
+
+	received := make(chan Event, 1)
+	Subscribe(func(ev Event) {
+		if ev.TaskID == task.ID {
+			select {
+			case received <- ev:
+			default:
+			}
+		}
+	})
+	if err := tasks.Assign(task.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Solve(task.ID, 2, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Событие уходит через брокер в памяти (см. setupDB) в очередь сервера
+	publishPending()
+	select {
+	case ev := <-received:
+		if ev.Status != models.StatusSolved {
+			t.Fatalf("received %+v, want a solved event", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("result event was not delivered")
+	}
+
+	var unpublished int
+	config.DB.QueryRow("SELECT COUNT(*) FROM result_events WHERE published_at IS NULL").Scan(&unpublished)
+	if unpublished != 0 {
+		t.Fatalf("%d events left unpublished", unpublished)
+	}
+}
+
+func TestFailedHandlerLeavesEventForRedelivery(t *testing.T) {
+	setupDB(t)
+	task := newTask(t)
+	if err := tasks.Assign(task.ID, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Solve(task.ID, 2, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	fail := true
+	SubscribeTx(func(tx *sql.Tx, ev Event) error {
+		if fail && ev.TaskID == task.ID {
+			return errTest
+		}
+		return nil
+	})
+
+	_, payloads := outbox(t)
+	if err := handle(payloads[0]); !errors.Is(err, errTest) {
+		t.Fatalf("handle() = %v, want the subscriber error", err)
+	}
+	var consumed int
+	config.DB.QueryRow("SELECT COUNT(*) FROM result_events WHERE consumed_at IS NOT NULL").Scan(&consumed)
+	if stats, _ := StatsByType(7); consumed != 0 || len(stats) != 0 {
+		t.Fatalf("failed handling left %d events consumed and stats %+v, want neither", consumed, stats)
+	}
+
+	// Брокер доставляет сообщение снова
+	fail = false
+	if err := handle(payloads[0]); err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := StatsByType(7); len(stats) != 1 || stats[0].Solved != 1 {
+		t.Fatalf("stats after redelivery = %+v, want one solved task", stats)
+	}
+}
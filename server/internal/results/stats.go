@@ -0,0 +1,74 @@
+package results
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"database/sql"
+	"fmt"
+)
+
+// TypeStats — итоги задач одного типа капчи за период
+type TypeStats struct {
+	CaptchaType string
+	Solved      int
+	Failed      int
+	Expired     int
+	Cancelled   int
+}
+
+// Total возвращает число завершённых задач
+func (s TypeStats) Total() int {
+	return s.Solved + s.Failed + s.Expired + s.Cancelled
+}
+
+// SuccessRate возвращает долю решённых задач в процентах
+func (s TypeStats) SuccessRate() float64 {
+	if s.Total() == 0 {
+		return 0
+	}
+	return float64(s.Solved) * 100 / float64(s.Total())
+}
+
+// countResult учитывает событие в дневной статистике в транзакции обработки события
+func countResult(tx *sql.Tx, ev Event) error {
+	_, err := tx.Exec(`INSERT INTO result_stats (day, captcha_type, status, count)
+		VALUES (date(?), ?, ?, 1)
+		ON CONFLICT(day, captcha_type, status) DO UPDATE SET count = count + 1`,
+		ev.OccurredAt, ev.CaptchaType, ev.Status)
+	return err
+}
+
+// StatsByType возвращает итоги по типам капчи за последние days дней
+func StatsByType(days int) ([]TypeStats, error) {
+	rows, err := config.DB.Query(`SELECT captcha_type, status, SUM(count) FROM result_stats
+		WHERE day > date('now', ?) GROUP BY captcha_type, status ORDER BY captcha_type`,
+		fmt.Sprintf("-%d days", days))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []TypeStats
+	for rows.Next() {
+		var captchaType, status string
+		var count int
+		if err := rows.Scan(&captchaType, &status, &count); err != nil {
+			return nil, err
+		}
+		if len(stats) == 0 || stats[len(stats)-1].CaptchaType != captchaType {
+			stats = append(stats, TypeStats{CaptchaType: captchaType})
+		}
+		s := &stats[len(stats)-1]
+		switch status {
+		case models.StatusSolved:
+			s.Solved = count
+		case models.StatusFailed:
+			s.Failed = count
+		case models.StatusExpired:
+			s.Expired = count
+		case models.StatusCancelled:
+			s.Cancelled = count
+		}
+	}
+	return stats, rows.Err()
+}
@@ -13,8 +13,12 @@ func SetupRoutes(app *fiber.App) {
 	// API routes - повинні бути першими, щоб уникнути конфлікту з сесійною аутентифікацією
 	apiGroup := app.Group("/api")
 	apiGroup.Post("/captcha/submit", middleware.APIKeyMiddleware, handlers.SubmitCaptcha) // Прийом капчі від клієнта
+	apiGroup.Post("/captcha/submit-batch", middleware.APIKeyMiddleware, handlers.SubmitCaptchaBatch)
 	apiGroup.Get("/captcha/result/:id", middleware.APIKeyMiddleware, handlers.GetCaptchaResult)
+	apiGroup.Get("/captcha/results", middleware.APIKeyMiddleware, handlers.GetCaptchaResults)
 	apiGroup.Post("/captcha/solution", middleware.APIKeyMiddleware, handlers.SubmitSolution)
+	apiGroup.Post("/captcha/report/:id", middleware.APIKeyMiddleware, handlers.ReportSolution)
+	apiGroup.Delete("/captcha/:id", middleware.APIKeyMiddleware, handlers.CancelCaptcha)
 
 	// Public routes
 	app.Get("/login", handlers.ShowLoginPage)
@@ -22,6 +26,7 @@ func SetupRoutes(app *fiber.App) {
 	app.Get("/register", handlers.ShowRegisterPage)
 	app.Post("/register", handlers.HandleRegister)
 	app.Get("/logout", handlers.HandleLogout)
+	app.Get("/health", handlers.Health)
 
 	// Add websocket route with auth check
 	app.Get("/socket", websocket.New(handlers.HandleWebSocket))
@@ -39,8 +44,20 @@ func SetupRoutes(app *fiber.App) {
 	adminGroup.Get("/users", handlers.ShowUsersAdmin)
 	adminGroup.Post("/users", handlers.CreateUser)
 	adminGroup.Delete("/users/:id", handlers.DeleteUser)
+	adminGroup.Post("/users/:id/adjust", handlers.AdjustBalance)
+	adminGroup.Post("/users/:id/tier", handlers.SetUserTier)
+	adminGroup.Post("/users/:id/share", handlers.SetUserShareWeight)
+	adminGroup.Post("/users/:id/types", handlers.SetUserAllowedTypes)
+	adminGroup.Post("/users/:id/reputation/reset", handlers.ResetReputation)
+	adminGroup.Get("/prices", handlers.ShowPricesAdmin)
+	adminGroup.Post("/prices", handlers.CreatePrice)
+	adminGroup.Delete("/prices/:id", handlers.DeletePrice)
 	adminGroup.Get("/tasks", handlers.ShowAdminTaskList)
 	adminGroup.Delete("/tasks/:id", handlers.DeleteTask)
+	adminGroup.Post("/tasks/:id/refund", handlers.RefundTask)
+	adminGroup.Get("/dead-letters", handlers.ShowDeadLetters)
+	adminGroup.Post("/dead-letters/:id/replay", handlers.ReplayDeadLetter)
+	adminGroup.Delete("/dead-letters/:id", handlers.DiscardDeadLetter)
 
 	// Worker routes (with prefix /worker)
 	workerGroup := authGroup.Group("/worker", middleware.RoleMiddleware("admin", "worker"))
@@ -53,6 +70,7 @@ func SetupRoutes(app *fiber.App) {
 	clientGroup := authGroup.Group("/client", middleware.RoleMiddleware("admin", "client"))
 	clientGroup.Get("/", handlers.ShowClientDashboard)
 	clientGroup.Get("/api-key/regenerate", data.RegenerateAPIKey)
+	clientGroup.Post("/webhooks/:id/redeliver", handlers.RedeliverWebhook)
 
 	// Shared API endpoints
 	authGroup.Get("/api/next-task", handlers.GetNextTask)
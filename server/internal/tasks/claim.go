@@ -0,0 +1,88 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"database/sql"
+	"errors"
+)
+
+var ErrNoTasks = errors.New("no tasks available")
+
+// Claim атомарно выбирает самую старую ожидающую задачу, срок ожидания которой
+// ещё не истёк, и назначает её исполнителю (pending → assigned). Уровень
+// приоритета, а внутри него клиент выбираются взвешенной справедливой
+// очередью (scheduler); если у выбранного клиента или на выбранном уровне
+// задач уже нет, берётся самая старая задача самого высокого уровня. Выбор и
+// назначение выполняются одним условным UPDATE ... RETURNING, поэтому одна
+// задача никогда не достанется двум исполнителям одновременно. Если очередь
+// пуста, возвращает ErrNoTasks.
+//
+// Выдаются только задачи тех типов капчи, которые исполнитель заявил (types;
+// пустой список — любые) и которые ему разрешил администратор (AllowedTypes).
+func Claim(solverID int64, types ...string) (*models.CaptchaTask, error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	allowed, err := allowedTypes(tx, solverID)
+	if err != nil {
+		return nil, err
+	}
+	types, ok := intersectTypes(NormalizeTypes(types), allowed)
+	if !ok {
+		return nil, ErrNoTasks
+	}
+	filter, filterArgs := typeFilter(types)
+
+	active, err := activePriorities(tx, filter, filterArgs)
+	if err != nil {
+		return nil, err
+	}
+	if len(active) == 0 {
+		return nil, ErrNoTasks
+	}
+	level := int(queue.pick(active))
+	clients, err := activeClients(tx, level, filter, filterArgs)
+	if err != nil {
+		return nil, err
+	}
+	client := clientQueue(level).pick(clients)
+
+	args := []interface{}{models.StatusAssigned, solverID, leaseModifier(), models.StatusPending}
+	args = append(args, filterArgs...)
+	args = append(args, level, client, models.StatusPending)
+	task, err := scanTask(tx.QueryRow(`
+		UPDATE tasks
+		SET status = ?, solver_id = ?, assigned_at = datetime('now'), lease_expires_at = datetime('now', ?)
+		WHERE id = (
+			SELECT id FROM tasks
+			WHERE status = ? AND `+notOverdue+` AND `+filter+`
+			ORDER BY priority = ? DESC, user_id = ? DESC, priority DESC, created_at ASC, id ASC
+			LIMIT 1
+		) AND status = ?
+		RETURNING `+columns, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoTasks
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ev := Event{TaskID: task.ID, UserID: task.UserID, SolverID: task.SolverID, From: models.StatusPending, To: models.StatusAssigned}
+	if err := runTxHooks(tx, ev); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	queue.served(int64(task.Priority), priorityWeight(task.Priority))
+	if weight, ok := clients[task.UserID]; ok && task.Priority == level {
+		clientQueue(level).served(task.UserID, weight)
+	}
+
+	publish(ev)
+	return task, nil
+}
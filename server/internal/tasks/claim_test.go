@@ -0,0 +1,95 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/db"
+	"captcha-solver/internal/models"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func setupDB(t *testing.T) {
+	t.Helper()
+	config.DBPath = filepath.Join(t.TempDir(), "test.db")
+	db.DB_Connect()
+	t.Cleanup(func() { config.DB.Close() })
+
+	// Состояние планировщиков относится к прежней базе
+	queue = newScheduler()
+	clientQueues = make(map[int]*scheduler)
+}
+
+func TestClaimConcurrentWorkers(t *testing.T) {
+	setupDB(t)
+
+	const (
+		taskCount   = 500
+		workerCount = 300
+	)
+
+	for i := 0; i < taskCount; i++ {
+		if err := Create(&models.CaptchaTask{UserID: 1, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}); err != nil {
+			t.Fatalf("create task: %v", err)
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		claimed = make(map[int64]int64) // task id → worker id
+		wg      sync.WaitGroup
+	)
+
+	for w := 1; w <= workerCount; w++ {
+		wg.Add(1)
+		go func(workerID int64) {
+			defer wg.Done()
+			for {
+				task, err := Claim(workerID)
+				if errors.Is(err, ErrNoTasks) {
+					return
+				}
+				if err != nil {
+					t.Errorf("worker %d: claim: %v", workerID, err)
+					return
+				}
+				if task.Status != models.StatusAssigned || task.SolverID == nil || *task.SolverID != workerID {
+					t.Errorf("worker %d: unexpected claimed task %+v", workerID, task)
+				}
+
+				mu.Lock()
+				if prev, ok := claimed[task.ID]; ok {
+					t.Errorf("task #%d handed out twice: to worker %d and %d", task.ID, prev, workerID)
+				}
+				claimed[task.ID] = workerID
+				mu.Unlock()
+
+				if err := Solve(task.ID, workerID, "token"); err != nil {
+					t.Errorf("worker %d: solve task #%d: %v", workerID, task.ID, err)
+				}
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+
+	if len(claimed) != taskCount {
+		t.Fatalf("claimed %d tasks, want %d", len(claimed), taskCount)
+	}
+
+	var solved int
+	if err := config.DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE status = ?", models.StatusSolved).Scan(&solved); err != nil {
+		t.Fatal(err)
+	}
+	if solved != taskCount {
+		t.Fatalf("solved %d tasks, want %d", solved, taskCount)
+	}
+}
+
+func TestClaimEmptyQueue(t *testing.T) {
+	setupDB(t)
+
+	if _, err := Claim(1); !errors.Is(err, ErrNoTasks) {
+		t.Fatalf("Claim on empty queue: got %v, want ErrNoTasks", err)
+	}
+}
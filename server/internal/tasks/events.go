@@ -0,0 +1,74 @@
+package tasks
+
+import (
+	"captcha-solver/internal/models"
+	"database/sql"
+	"sync"
+)
+
+// Event описывает смену статуса задачи. From пуст для только что созданной задачи.
+type Event struct {
+	TaskID   int64
+	UserID   int64
+	SolverID *int64 // исполнитель задачи; при снятии с исполнителя — прежний
+	From     string
+	To       string
+
+	// Abandoned отмечает возврат задачи, брошенной исполнителем (Abandon):
+	// по истечении аренды или при разрыве соединения
+	Abandoned bool
+}
+
+// Revoked сообщает, что задача завершена, пока над ней работал исполнитель
+// (например, отменена клиентом), и исполнителю пора прекратить работу над ней
+func (ev Event) Revoked() bool {
+	return ev.From == models.StatusAssigned && ev.SolverID != nil &&
+		IsTerminal(ev.To) && ev.To != models.StatusSolved
+}
+
+// Listener вызывается после того, как переход сохранён в БД
+type Listener func(Event)
+
+// TxHook вызывается внутри транзакции, в которой меняется статус задачи,
+// до её фиксации. Ошибка хука откатывает переход целиком, поэтому связанные
+// записи (например, проводки по балансу) сохраняются атомарно с задачей.
+type TxHook func(tx *sql.Tx, ev Event) error
+
+var (
+	listenersMu sync.RWMutex
+	listeners   []Listener
+	txHooks     []TxHook
+)
+
+// Subscribe регистрирует обработчик смены статусов задач
+func Subscribe(l Listener) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	listeners = append(listeners, l)
+}
+
+// SubscribeTx регистрирует хук, выполняемый в транзакции перехода
+func SubscribeTx(h TxHook) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	txHooks = append(txHooks, h)
+}
+
+func runTxHooks(tx *sql.Tx, ev Event) error {
+	listenersMu.RLock()
+	defer listenersMu.RUnlock()
+	for _, h := range txHooks {
+		if err := h(tx, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func publish(ev Event) {
+	listenersMu.RLock()
+	defer listenersMu.RUnlock()
+	for _, l := range listeners {
+		l(ev)
+	}
+}
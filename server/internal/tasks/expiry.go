@@ -0,0 +1,63 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"log"
+	"time"
+)
+
+// notOverdue — условие SQL для задач, срок ожидания которых ещё не истёк.
+// Просроченные задачи не выдаются и не учитываются в очереди, даже если
+// RunExpirer ещё не успел перевести их в expired.
+const notOverdue = "(deadline_at IS NULL OR deadline_at > datetime('now'))"
+
+// MaxWaitFor возвращает время ожидания по умолчанию для типа капчи
+func MaxWaitFor(captchaType string) time.Duration {
+	if d, ok := config.TaskMaxWaitByType[captchaType]; ok && d > 0 {
+		return d
+	}
+	return config.TaskMaxWait
+}
+
+// TokenValidityFor возвращает срок действия токена решения для типа капчи
+func TokenValidityFor(captchaType string) time.Duration {
+	if d, ok := config.TokenValidityByType[captchaType]; ok && d > 0 {
+		return d
+	}
+	return config.TokenValidity
+}
+
+// QueueDepth возвращает число задач, ожидающих исполнителя
+func QueueDepth() (int, error) {
+	var count int
+	err := config.DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE status = ? AND "+notOverdue, models.StatusPending).Scan(&count)
+	return count, err
+}
+
+// RunExpirer периодически переводит в expired задачи, не решённые до deadline_at
+func RunExpirer() {
+	ticker := time.NewTicker(config.ExpirerInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expireOverdue()
+	}
+}
+
+func expireOverdue() {
+	rows, err := config.DB.Query("SELECT id FROM tasks WHERE status IN (?, ?) AND deadline_at <= datetime('now')",
+		models.StatusPending, models.StatusAssigned)
+	if err != nil {
+		log.Println("Error loading overdue tasks:", err)
+		return
+	}
+
+	for _, id := range collectIDs(rows) {
+		if err := Expire(id); err != nil {
+			log.Printf("Error expiring task #%d: %v", id, err)
+			continue
+		}
+		log.Printf("⌛ Task #%d expired: not solved within its max wait", id)
+	}
+}
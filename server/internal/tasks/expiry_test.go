@@ -0,0 +1,92 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaxWaitFor(t *testing.T) {
+	saved := config.TaskMaxWaitByType
+	t.Cleanup(func() { config.TaskMaxWaitByType = saved })
+	config.TaskMaxWaitByType = map[string]time.Duration{"recaptcha": 90 * time.Second}
+
+	if got := MaxWaitFor("recaptcha"); got != 90*time.Second {
+		t.Fatalf("MaxWaitFor(recaptcha) = %v, want 90s", got)
+	}
+	if got := MaxWaitFor("funcaptcha"); got != config.TaskMaxWait {
+		t.Fatalf("MaxWaitFor(funcaptcha) = %v, want default %v", got, config.TaskMaxWait)
+	}
+}
+
+func TestOverdueTasksExpire(t *testing.T) {
+	setupDB(t)
+	pending := newTask(t, 10)
+	assigned := newTask(t, 10)
+	fresh := newTask(t, 10)
+	if pending.DeadlineAt == nil {
+		t.Fatal("created task has no deadline")
+	}
+	if err := Assign(assigned.ID, 20); err != nil {
+		t.Fatal(err)
+	}
+	config.DB.Exec("UPDATE tasks SET deadline_at = datetime('now', '-1 second') WHERE id IN (?, ?)", pending.ID, assigned.ID)
+
+	// Просроченная задача не выдаётся и не считается в очереди ещё до перевода в expired
+	if depth, err := QueueDepth(); err != nil || depth != 1 {
+		t.Fatalf("QueueDepth() = %d, %v; want 1", depth, err)
+	}
+	claimed, err := Claim(21)
+	if err != nil || claimed.ID != fresh.ID {
+		t.Fatalf("Claim() = %+v, %v; want task #%d", claimed, err, fresh.ID)
+	}
+	if _, err := Claim(22); !errors.Is(err, ErrNoTasks) {
+		t.Fatalf("Claim() with only overdue tasks: err = %v, want ErrNoTasks", err)
+	}
+
+	expireOverdue()
+
+	for _, id := range []int64{pending.ID, assigned.ID} {
+		task, _ := Get(id)
+		if task.Status != models.StatusExpired {
+			t.Fatalf("task #%d status = %s, want expired", id, task.Status)
+		}
+	}
+	if task, _ := Get(fresh.ID); task.Status != models.StatusAssigned {
+		t.Fatalf("fresh task status = %s, want assigned", task.Status)
+	}
+}
+
+func TestSolvedTokenGoesStale(t *testing.T) {
+	setupDB(t)
+	task := newTask(t, 10)
+	if err := Assign(task.ID, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := Solve(task.ID, 20, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	solved, err := Get(task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if solved.SolvedAt == nil || solved.ExpiresAt == nil || solved.Stale {
+		t.Fatalf("fresh solution: solved_at=%v expires_at=%v stale=%v", solved.SolvedAt, solved.ExpiresAt, solved.Stale)
+	}
+
+	config.DB.Exec("UPDATE tasks SET expires_at = datetime('now', '-1 second') WHERE id = ?", task.ID)
+	stale, err := Resolve(task.PublicID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stale.Stale {
+		t.Fatal("solution past expires_at is not stale")
+	}
+	stale.RedactStale()
+	if stale.CaptchaResponse != nil {
+		t.Fatal("stale token is still returned")
+	}
+}
@@ -0,0 +1,85 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// Границы веса клиента (колонка users.share_weight)
+const (
+	MinShareWeight = 1
+	MaxShareWeight = 100
+)
+
+var ErrInvalidShareWeight = errors.New("share weight must be between 1 and 100")
+
+// Внутри уровня приоритета задачи раздаются клиентам по очереди с учётом
+// их весов, поэтому пачка задач одного клиента не задерживает остальных:
+// клиент с весом 2 получает вдвое больше выдач, чем клиент с весом 1, пока
+// у обоих есть задачи в очереди.
+var (
+	clientQueuesMu sync.Mutex
+	clientQueues   = make(map[int]*scheduler) // по уровням приоритета
+)
+
+// clientQueue возвращает планировщик клиентов уровня приоритета
+func clientQueue(level int) *scheduler {
+	clientQueuesMu.Lock()
+	defer clientQueuesMu.Unlock()
+
+	s, ok := clientQueues[level]
+	if !ok {
+		s = newScheduler()
+		clientQueues[level] = s
+	}
+	return s
+}
+
+// activeClients возвращает клиентов с подходящими под filter ожидающими
+// задачами уровня level и их веса
+func activeClients(tx *sql.Tx, level int, filter string, filterArgs []interface{}) (map[int64]float64, error) {
+	args := append([]interface{}{models.StatusPending, level}, filterArgs...)
+	rows, err := tx.Query(`
+		SELECT DISTINCT t.user_id, COALESCE(u.share_weight, 1)
+		FROM tasks t
+		LEFT JOIN users u ON u.id = t.user_id
+		WHERE t.status = ? AND t.priority = ? AND `+notOverdue+` AND `+filter, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := make(map[int64]float64)
+	for rows.Next() {
+		var (
+			userID int64
+			weight int
+		)
+		if err := rows.Scan(&userID, &weight); err != nil {
+			return nil, err
+		}
+		if weight < MinShareWeight {
+			weight = MinShareWeight
+		}
+		clients[userID] = float64(weight)
+	}
+	return clients, rows.Err()
+}
+
+// SetShareWeight задаёт вес клиента при разделении очереди между клиентами
+func SetShareWeight(userID int64, weight int) error {
+	if weight < MinShareWeight || weight > MaxShareWeight {
+		return ErrInvalidShareWeight
+	}
+	res, err := config.DB.Exec("UPDATE users SET share_weight = ? WHERE id = ?", weight, userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
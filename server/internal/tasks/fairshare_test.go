@@ -0,0 +1,73 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"errors"
+	"testing"
+)
+
+func createClient(t *testing.T, username string) int64 {
+	t.Helper()
+	res, err := config.DB.Exec("INSERT INTO users (username, password_hash, role) VALUES (?, '', 'client')", username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func submit(t *testing.T, userID int64, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := Create(&models.CaptchaTask{UserID: userID, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func claimOwners(t *testing.T, n int) map[int64]int {
+	t.Helper()
+	owners := make(map[int64]int)
+	for i := 0; i < n; i++ {
+		task, err := Claim(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		owners[task.UserID]++
+	}
+	return owners
+}
+
+func TestBurstDoesNotBlockOtherClients(t *testing.T) {
+	setupDB(t)
+	burst, small, other := createClient(t, "burst"), createClient(t, "small"), createClient(t, "other")
+
+	submit(t, burst, 50)
+	submit(t, small, 3)
+	submit(t, other, 3)
+
+	owners := claimOwners(t, 9)
+	if owners[small] != 3 || owners[other] != 3 {
+		t.Fatalf("first 9 claims by client = %v, want all 3 tasks of each small client served", owners)
+	}
+}
+
+func TestShareWeight(t *testing.T) {
+	setupDB(t)
+	heavy, light := createClient(t, "heavy"), createClient(t, "light")
+	if err := SetShareWeight(heavy, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetShareWeight(light, 0); !errors.Is(err, ErrInvalidShareWeight) {
+		t.Fatalf("SetShareWeight(0) err = %v, want ErrInvalidShareWeight", err)
+	}
+
+	submit(t, heavy, 10)
+	submit(t, light, 10)
+
+	owners := claimOwners(t, 9)
+	if owners[heavy] != 6 || owners[light] != 3 {
+		t.Fatalf("claims by client = %v, want 6 for weight 2 and 3 for weight 1", owners)
+	}
+}
@@ -0,0 +1,96 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrIdempotencyConflict — ключ идемпотентности уже использован клиентом
+// для другого запроса
+var ErrIdempotencyConflict = errors.New("idempotency key was already used with a different request")
+
+// CreateIdempotent создаёт задачу, как Create, но не более одного раза на
+// ключ key клиента task.UserID в течение config.IdempotencyRetention.
+// fingerprint — отпечаток запроса: повтор с тем же ключом и отпечатком
+// возвращает исходную задачу и replayed == true, с другим отпечатком —
+// ErrIdempotencyConflict. Поиск ключа и создание задачи идут в одной
+// транзакции, поэтому параллельные повторы не создадут дубликат.
+func CreateIdempotent(task *models.CaptchaTask, key, fingerprint string) (result *models.CaptchaTask, replayed bool, err error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var (
+		taskID   int64
+		existing string
+	)
+	err = tx.QueryRow(`SELECT k.task_id, k.fingerprint FROM idempotency_keys k
+		JOIN tasks t ON t.id = k.task_id
+		WHERE k.user_id = ? AND k.key = ? AND k.created_at > datetime('now', ?)`,
+		task.UserID, key, retentionModifier()).Scan(&taskID, &existing)
+	switch {
+	case err == nil && existing != fingerprint:
+		return nil, false, ErrIdempotencyConflict
+	case err == nil:
+		tx.Rollback()
+		original, err := Get(taskID)
+		return original, err == nil, err
+	case err != sql.ErrNoRows:
+		return nil, false, err
+	}
+
+	// Ключ с истёкшим сроком хранения или удалённой задачей используется заново
+	if _, err := tx.Exec("DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?", task.UserID, key); err != nil {
+		return nil, false, err
+	}
+	ev, err := insert(tx, task)
+	if err != nil {
+		return nil, false, err
+	}
+	_, err = tx.Exec("INSERT INTO idempotency_keys (user_id, key, fingerprint, task_id) VALUES (?, ?, ?, ?)",
+		task.UserID, key, fingerprint, task.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	task.Status = models.StatusPending
+	publish(ev)
+	return task, false, nil
+}
+
+// RunIdempotencyPurger периодически удаляет ключи идемпотентности старше
+// config.IdempotencyRetention
+func RunIdempotencyPurger() {
+	ticker := time.NewTicker(config.IdempotencyPurgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purgeIdempotencyKeys()
+	}
+}
+
+// purgeIdempotencyKeys удаляет ключи, срок хранения которых истёк
+func purgeIdempotencyKeys() {
+	res, err := config.DB.Exec("DELETE FROM idempotency_keys WHERE created_at <= datetime('now', ?)", retentionModifier())
+	if err != nil {
+		log.Println("Error purging idempotency keys:", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("🧹 Purged %d expired idempotency keys", n)
+	}
+}
+
+func retentionModifier() string {
+	return fmt.Sprintf("-%d seconds", int(config.IdempotencyRetention.Seconds()))
+}
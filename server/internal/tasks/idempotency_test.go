@@ -0,0 +1,46 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"errors"
+	"testing"
+)
+
+func TestCreateIdempotent(t *testing.T) {
+	setupDB(t)
+	client, other := createClient(t, "client"), createClient(t, "other")
+	newTask := func(userID int64) *models.CaptchaTask {
+		return &models.CaptchaTask{UserID: userID, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	}
+
+	first, replayed, err := CreateIdempotent(newTask(client), "retry-1", "body")
+	if err != nil || replayed {
+		t.Fatalf("first CreateIdempotent() = replayed %v, err %v; want a new task", replayed, err)
+	}
+	again, replayed, err := CreateIdempotent(newTask(client), "retry-1", "body")
+	if err != nil || !replayed || again.ID != first.ID {
+		t.Fatalf("repeated CreateIdempotent() = task #%d, replayed %v, err %v; want task #%d replayed", again.ID, replayed, err, first.ID)
+	}
+	if _, _, err := CreateIdempotent(newTask(client), "retry-1", "other body"); !errors.Is(err, ErrIdempotencyConflict) {
+		t.Fatalf("CreateIdempotent() with a different body: err = %v, want ErrIdempotencyConflict", err)
+	}
+
+	// Ключи разных клиентов независимы
+	if _, replayed, err := CreateIdempotent(newTask(other), "retry-1", "body"); err != nil || replayed {
+		t.Fatalf("CreateIdempotent() by another client = replayed %v, err %v; want a new task", replayed, err)
+	}
+
+	// После срока хранения ключ можно использовать снова
+	config.DB.Exec("UPDATE idempotency_keys SET created_at = datetime('now', '-2 days')")
+	purgeIdempotencyKeys()
+	if fresh, replayed, err := CreateIdempotent(newTask(client), "retry-1", "other body"); err != nil || replayed || fresh.ID == first.ID {
+		t.Fatalf("CreateIdempotent() after retention = replayed %v, err %v; want a new task", replayed, err)
+	}
+
+	var count int
+	config.DB.QueryRow("SELECT COUNT(*) FROM tasks").Scan(&count)
+	if count != 3 {
+		t.Fatalf("%d tasks stored, want 3", count)
+	}
+}
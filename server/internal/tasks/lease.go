@@ -0,0 +1,165 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// leaseModifier возвращает модификатор datetime() для срока аренды
+func leaseModifier() string {
+	return fmt.Sprintf("+%d seconds", int(config.LeaseDuration.Seconds()))
+}
+
+// RenewLease продлевает аренду задачи, уже назначенной исполнителю
+func RenewLease(taskID, solverID int64) error {
+	res, err := config.DB.Exec("UPDATE tasks SET lease_expires_at = datetime('now', ?) WHERE id = ? AND status = ? AND solver_id = ?",
+		leaseModifier(), taskID, models.StatusAssigned, solverID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrNotAssignee
+	}
+	return nil
+}
+
+// Abandon возвращает брошенную исполнителем задачу в очередь и засчитывает
+// попытку. Когда число попыток достигает config.MaxAttempts, задача
+// переводится в failed.
+func Abandon(taskID, solverID int64) error {
+	var attempts int
+	if err := config.DB.QueryRow("SELECT attempts FROM tasks WHERE id = ?", taskID).Scan(&attempts); err != nil {
+		return err
+	}
+	attempts++
+
+	if attempts >= config.MaxAttempts {
+		return transitionEvent(Event{TaskID: taskID, To: models.StatusFailed, Abandoned: true}, &solverID,
+			"attempts = attempts + 1, lease_expires_at = NULL, error_message = ?",
+			fmt.Sprintf("abandoned by workers %d times", attempts))
+	}
+	return transitionEvent(Event{TaskID: taskID, To: models.StatusPending, Abandoned: true}, &solverID,
+		"attempts = attempts + 1, solver_id = NULL, lease_expires_at = NULL")
+}
+
+// Leases — задачи, выданные исполнителю через одно соединение. При закрытии
+// соединения освобождаются только они: задачи, взятые исполнителем через REST
+// или другие его соединения, продолжают работу.
+type Leases struct {
+	solverID int64
+
+	mu  sync.Mutex
+	ids map[int64]struct{}
+}
+
+// NewLeases создаёт пустой набор аренд исполнителя
+func NewLeases(solverID int64) *Leases {
+	return &Leases{solverID: solverID, ids: make(map[int64]struct{})}
+}
+
+// Add запоминает задачу, выданную через соединение
+func (l *Leases) Add(taskID int64) {
+	l.mu.Lock()
+	l.ids[taskID] = struct{}{}
+	l.mu.Unlock()
+}
+
+// Remove забывает задачу, например после отправки решения
+func (l *Leases) Remove(taskID int64) {
+	l.mu.Lock()
+	delete(l.ids, taskID)
+	l.mu.Unlock()
+}
+
+// Has сообщает, выдана ли задача через это соединение
+func (l *Leases) Has(taskID int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.ids[taskID]
+	return ok
+}
+
+// Abandon освобождает задачи набора, которые всё ещё назначены исполнителю.
+// Вызывается при закрытии соединения.
+func (l *Leases) Abandon() {
+	l.mu.Lock()
+	held := l.ids
+	l.ids = make(map[int64]struct{})
+	l.mu.Unlock()
+	if len(held) == 0 {
+		return
+	}
+
+	rows, err := config.DB.Query("SELECT id FROM tasks WHERE status = ? AND solver_id = ?", models.StatusAssigned, l.solverID)
+	if err != nil {
+		log.Printf("Error loading leases of worker %d: %v", l.solverID, err)
+		return
+	}
+	for _, id := range collectIDs(rows) {
+		if _, ok := held[id]; !ok {
+			continue
+		}
+		if err := Abandon(id, l.solverID); err != nil {
+			log.Printf("Error releasing task #%d of worker %d: %v", id, l.solverID, err)
+			continue
+		}
+		log.Printf("Task #%d released: worker %d disconnected", id, l.solverID)
+	}
+}
+
+// collectIDs читает колонку id из результата запроса и закрывает его
+func collectIDs(rows *sql.Rows) []int64 {
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// RunLeaseReaper периодически возвращает в очередь задачи с истёкшей арендой
+func RunLeaseReaper() {
+	ticker := time.NewTicker(config.ReaperInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		reapExpiredLeases()
+	}
+}
+
+func reapExpiredLeases() {
+	rows, err := config.DB.Query("SELECT id, solver_id FROM tasks WHERE status = ? AND lease_expires_at < datetime('now')", models.StatusAssigned)
+	if err != nil {
+		log.Println("Error loading expired leases:", err)
+		return
+	}
+
+	type lease struct{ taskID, solverID int64 }
+	var expired []lease
+	for rows.Next() {
+		var l lease
+		if err := rows.Scan(&l.taskID, &l.solverID); err != nil {
+			continue
+		}
+		expired = append(expired, l)
+	}
+	rows.Close()
+
+	for _, l := range expired {
+		if err := Abandon(l.taskID, l.solverID); err != nil {
+			log.Printf("Error reclaiming task #%d: %v", l.taskID, err)
+			continue
+		}
+		log.Printf("Lease of task #%d expired, reclaimed from worker %d", l.taskID, l.solverID)
+	}
+}
@@ -0,0 +1,33 @@
+package tasks
+
+import (
+	"captcha-solver/internal/models"
+	"testing"
+)
+
+func TestLeasesAbandonOnlyOwnTasks(t *testing.T) {
+	setupDB(t)
+	const worker = 20
+	overSocket, overREST, overOtherSocket := newTask(t, 1), newTask(t, 1), newTask(t, 1)
+	for _, task := range []*models.CaptchaTask{overSocket, overREST, overOtherSocket} {
+		if err := Assign(task.ID, worker); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn, other := NewLeases(worker), NewLeases(worker)
+	conn.Add(overSocket.ID)
+	other.Add(overOtherSocket.ID)
+	conn.Abandon()
+
+	released, _ := Get(overSocket.ID)
+	if released.Status != models.StatusPending || released.Attempts != 1 {
+		t.Fatalf("task leased over the closed socket: status %s, attempts %d; want pending after one attempt", released.Status, released.Attempts)
+	}
+	for _, task := range []*models.CaptchaTask{overREST, overOtherSocket} {
+		kept, _ := Get(task.ID)
+		if kept.Status != models.StatusAssigned || kept.Attempts != 0 {
+			t.Fatalf("task #%d: status %s, attempts %d; want it still assigned", task.ID, kept.Status, kept.Attempts)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"database/sql"
+	"sync"
+)
+
+// Уровни приоритета задачи (колонка tasks.priority)
+const (
+	PriorityLow    = 0
+	PriorityNormal = 1
+	PriorityHigh   = 2
+)
+
+// priorityNames — имена уровней в API, от низшего к высшему
+var priorityNames = []string{"low", "normal", "high"}
+
+// PriorityName возвращает имя уровня приоритета для API
+func PriorityName(level int) string {
+	if level < PriorityLow || level > PriorityHigh {
+		return "unknown"
+	}
+	return priorityNames[level]
+}
+
+// ParsePriority разбирает имя уровня приоритета ("low", "normal", "high")
+func ParsePriority(name string) (int, bool) {
+	for level, n := range priorityNames {
+		if n == name {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// priorityWeight возвращает долю выдачи уровня: при заполненной очереди
+// задачи уровня с весом 4 выдаются вчетверо чаще, чем с весом 1
+func priorityWeight(level int) float64 {
+	var w int
+	switch level {
+	case PriorityHigh:
+		w = config.PriorityWeightHigh
+	case PriorityNormal:
+		w = config.PriorityWeightNormal
+	default:
+		w = config.PriorityWeightLow
+	}
+	if w < 1 {
+		w = 1
+	}
+	return float64(w)
+}
+
+// scheduler выбирает, чья очередь следующей получает задачу, по алгоритму
+// stride scheduling (разновидность взвешенной справедливой очереди): у каждой
+// очереди есть «проход», который после выдачи увеличивается на 1/вес, и
+// выдаётся очередь с наименьшим проходом. Очередь с большим весом
+// обслуживается чаще, но очередь с малым не голодает. Один планировщик
+// выбирает уровень приоритета, другие — клиента внутри уровня (fairshare.go).
+type scheduler struct {
+	mu    sync.Mutex
+	pass  map[int64]float64
+	vtime float64 // проход последней обслуженной очереди
+}
+
+var queue = newScheduler()
+
+func newScheduler() *scheduler {
+	return &scheduler{pass: make(map[int64]float64)}
+}
+
+// pick выбирает очередь среди непустых; active сопоставляет ключ очереди с её весом.
+// При равных проходах выигрывает больший вес, затем меньший ключ.
+func (s *scheduler) pick(active map[int64]float64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Простаивавшая очередь не копит право на внеочередную выдачу, поэтому
+	// проходы неактивных очередей можно не хранить
+	for key := range s.pass {
+		if _, ok := active[key]; !ok {
+			delete(s.pass, key)
+		}
+	}
+
+	var best int64
+	found := false
+	for key, weight := range active {
+		if s.pass[key] < s.vtime {
+			s.pass[key] = s.vtime
+		}
+		tie := found && s.pass[key] == s.pass[best]
+		if !found || s.pass[key] < s.pass[best] ||
+			(tie && (weight > active[best] || (weight == active[best] && key < best))) {
+			best, found = key, true
+		}
+	}
+	return best
+}
+
+// served отмечает выдачу задачи из очереди key с весом weight
+func (s *scheduler) served(key int64, weight float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pass[key] < s.vtime {
+		s.pass[key] = s.vtime
+	}
+	s.vtime = s.pass[key]
+	s.pass[key] += 1 / weight
+}
+
+// activePriorities возвращает уровни, на которых есть подходящие под filter
+// ожидающие задачи, с их весами
+func activePriorities(tx *sql.Tx, filter string, filterArgs []interface{}) (map[int64]float64, error) {
+	rows, err := tx.Query("SELECT DISTINCT priority FROM tasks WHERE status = ? AND "+notOverdue+" AND "+filter,
+		append([]interface{}{models.StatusPending}, filterArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	levels := make(map[int64]float64)
+	for rows.Next() {
+		var level int
+		if err := rows.Scan(&level); err != nil {
+			return nil, err
+		}
+		levels[int64(level)] = priorityWeight(level)
+	}
+	return levels, rows.Err()
+}
+
+// QueueDepthByPriority возвращает число ожидающих задач по именам уровней приоритета
+func QueueDepthByPriority() (map[string]int, error) {
+	rows, err := config.DB.Query("SELECT priority, COUNT(*) FROM tasks WHERE status = ? AND "+notOverdue+" GROUP BY priority",
+		models.StatusPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	depth := make(map[string]int, len(priorityNames))
+	for _, name := range priorityNames {
+		depth[name] = 0
+	}
+	for rows.Next() {
+		var level, count int
+		if err := rows.Scan(&level, &count); err != nil {
+			return nil, err
+		}
+		depth[PriorityName(level)] += count
+	}
+	return depth, rows.Err()
+}
@@ -0,0 +1,40 @@
+package tasks
+
+import (
+	"captcha-solver/internal/models"
+	"testing"
+)
+
+func TestClaimWeightedByPriority(t *testing.T) {
+	setupDB(t)
+
+	for i := 0; i < 20; i++ {
+		for _, level := range []int{PriorityLow, PriorityNormal, PriorityHigh} {
+			task := &models.CaptchaTask{UserID: 1, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com", Priority: level}
+			if err := Create(task); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// При весах 4:2:1 из 14 выдач high получает 8, normal — 4, low — 2
+	served := make(map[int]int)
+	for i := 0; i < 14; i++ {
+		task, err := Claim(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		served[task.Priority]++
+	}
+	if served[PriorityHigh] != 8 || served[PriorityNormal] != 4 || served[PriorityLow] != 2 {
+		t.Fatalf("served by priority = %v, want high 8, normal 4, low 2", served)
+	}
+
+	depth, err := QueueDepthByPriority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth["high"] != 12 || depth["normal"] != 16 || depth["low"] != 18 {
+		t.Fatalf("QueueDepthByPriority() = %v", depth)
+	}
+}
@@ -0,0 +1,124 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"database/sql"
+	"sort"
+	"strings"
+)
+
+// ParseTypes разбирает список типов капчи ("hcaptcha, recaptcha"): приводит
+// к нижнему регистру, убирает пустые значения и повторы
+func ParseTypes(raw string) []string {
+	return NormalizeTypes(strings.Split(raw, ","))
+}
+
+// NormalizeType приводит тип капчи к виду, в котором он хранится в задачах и
+// сравнивается с типами исполнителей: без пробелов, в нижнем регистре
+func NormalizeType(t string) string {
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// NormalizeTypes приводит список типов капчи к нижнему регистру и убирает
+// пустые значения и повторы; результат отсортирован
+func NormalizeTypes(types []string) []string {
+	seen := make(map[string]bool, len(types))
+	var result []string
+	for _, t := range types {
+		t = NormalizeType(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		result = append(result, t)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// AllowedTypes возвращает типы капчи, которыми администратор ограничил
+// исполнителя; пустой список означает, что ограничений нет
+func AllowedTypes(userID int64) ([]string, error) {
+	return allowedTypes(config.DB, userID)
+}
+
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func allowedTypes(q querier, userID int64) ([]string, error) {
+	var raw sql.NullString
+	err := q.QueryRow("SELECT allowed_types FROM users WHERE id = ?", userID).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseTypes(raw.String), nil
+}
+
+// SetAllowedTypes ограничивает типы капчи, которые выдаются исполнителю;
+// пустой список снимает ограничение
+func SetAllowedTypes(userID int64, types []string) error {
+	var value interface{}
+	if types = NormalizeTypes(types); len(types) > 0 {
+		value = strings.Join(types, ",")
+	}
+	res, err := config.DB.Exec("UPDATE users SET allowed_types = ? WHERE id = ?", value, userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// EffectiveTypes возвращает типы капчи, которые получит исполнитель: те из
+// заявленных им (declared), что разрешены администратором. ok == false
+// означает, что подходящих типов нет совсем; пустой список при ok == true —
+// что подходит любой тип.
+func EffectiveTypes(userID int64, declared []string) (types []string, ok bool, err error) {
+	allowed, err := AllowedTypes(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	types, ok = intersectTypes(NormalizeTypes(declared), allowed)
+	return types, ok, nil
+}
+
+// intersectTypes пересекает два списка типов, где пустой список означает «любые»
+func intersectTypes(declared, allowed []string) ([]string, bool) {
+	switch {
+	case len(declared) == 0:
+		return allowed, true
+	case len(allowed) == 0:
+		return declared, true
+	}
+
+	permitted := make(map[string]bool, len(allowed))
+	for _, t := range allowed {
+		permitted[t] = true
+	}
+	var types []string
+	for _, t := range declared {
+		if permitted[t] {
+			types = append(types, t)
+		}
+	}
+	return types, len(types) > 0
+}
+
+// typeFilter возвращает условие SQL на captcha_type для списка типов;
+// для пустого списка — условие, которому удовлетворяет любая задача
+func typeFilter(types []string) (string, []interface{}) {
+	if len(types) == 0 {
+		return "1", nil
+	}
+	args := make([]interface{}, len(types))
+	for i, t := range types {
+		args[i] = t
+	}
+	return "captcha_type IN (?" + strings.Repeat(", ?", len(types)-1) + ")", args
+}
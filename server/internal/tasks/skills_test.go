@@ -0,0 +1,86 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseTypes(t *testing.T) {
+	got := ParseTypes(" reCAPTCHA, hcaptcha,,recaptcha ")
+	if want := []string{"hcaptcha", "recaptcha"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseTypes() = %v, want %v", got, want)
+	}
+}
+
+func TestCreateNormalizesCaptchaType(t *testing.T) {
+	setupDB(t)
+	res, err := config.DB.Exec("INSERT INTO users (username, password_hash, role) VALUES ('solver', '', 'worker')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker, _ := res.LastInsertId()
+
+	task := &models.CaptchaTask{UserID: 1, CaptchaType: " hCaptcha ", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	if err := Create(task); err != nil {
+		t.Fatal(err)
+	}
+	if task.CaptchaType != "hcaptcha" {
+		t.Fatalf("CaptchaType = %q, want hcaptcha", task.CaptchaType)
+	}
+
+	// Исполнитель, заявивший hcaptcha, получает задачу, созданную как hCaptcha
+	claimed, err := Claim(worker, "hcaptcha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed.ID != task.ID || claimed.CaptchaType != "hcaptcha" {
+		t.Fatalf("Claim(hcaptcha) = task #%d (%s), want #%d", claimed.ID, claimed.CaptchaType, task.ID)
+	}
+}
+
+func TestClaimByCaptchaType(t *testing.T) {
+	setupDB(t)
+	res, err := config.DB.Exec("INSERT INTO users (username, password_hash, role) VALUES ('solver', '', 'worker')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker, _ := res.LastInsertId()
+
+	for _, captchaType := range []string{"hcaptcha", "recaptcha", "turnstile"} {
+		if err := Create(&models.CaptchaTask{UserID: 1, CaptchaType: captchaType, SiteKey: "sitekey", TargetURL: "https://example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	task, err := Claim(worker, "ReCaptcha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.CaptchaType != "recaptcha" {
+		t.Fatalf("Claim(recaptcha) returned a %s task", task.CaptchaType)
+	}
+	if _, err := Claim(worker, "recaptcha"); !errors.Is(err, ErrNoTasks) {
+		t.Fatalf("second Claim(recaptcha): err = %v, want ErrNoTasks", err)
+	}
+
+	// Администратор разрешил только hcaptcha: заявленный turnstile не выдаётся
+	if err := SetAllowedTypes(worker, []string{"hcaptcha"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := EffectiveTypes(worker, []string{"turnstile"}); ok {
+		t.Fatal("EffectiveTypes(turnstile) ok, want no permitted types")
+	}
+	if _, err := Claim(worker, "turnstile"); !errors.Is(err, ErrNoTasks) {
+		t.Fatalf("Claim(turnstile) by restricted worker: err = %v, want ErrNoTasks", err)
+	}
+	task, err = Claim(worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.CaptchaType != "hcaptcha" {
+		t.Fatalf("Claim() by restricted worker returned a %s task, want hcaptcha", task.CaptchaType)
+	}
+}
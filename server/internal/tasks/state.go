@@ -0,0 +1,45 @@
+package tasks
+
+import (
+	"captcha-solver/internal/models"
+	"errors"
+)
+
+var (
+	ErrTaskNotFound      = errors.New("task not found")
+	ErrInvalidTransition = errors.New("invalid task status transition")
+	ErrNotAssignee       = errors.New("task is not assigned to this solver")
+)
+
+// transitions описывает допустимые переходы между статусами задачи.
+// Статусы без исходящих переходов считаются конечными.
+var transitions = map[string][]string{
+	models.StatusPending: {
+		models.StatusAssigned,
+		models.StatusFailed,
+		models.StatusExpired,
+		models.StatusCancelled,
+	},
+	models.StatusAssigned: {
+		models.StatusPending, // задача возвращена в очередь
+		models.StatusSolved,
+		models.StatusFailed,
+		models.StatusExpired,
+		models.StatusCancelled,
+	},
+}
+
+// CanTransition reports whether a task may move from one status to another.
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal reports whether no further transitions are possible from status.
+func IsTerminal(status string) bool {
+	return len(transitions[status]) == 0
+}
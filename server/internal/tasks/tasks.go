@@ -0,0 +1,316 @@
+package tasks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/utils"
+	"database/sql"
+	"fmt"
+)
+
+// columns — полный список колонок задачи в порядке scanTask
+const columns = `id, public_id, user_id, solver_id, captcha_type, sitekey, target_url, captcha_response,
+	status, error_message, attempts, created_at, updated_at, solved_at, lease_expires_at, callback_url, price, payout, deadline_at,
+	expires_at, COALESCE(expires_at <= datetime('now'), 0), priority`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTask(row scanner) (*models.CaptchaTask, error) {
+	var task models.CaptchaTask
+	err := row.Scan(
+		&task.ID,
+		&task.PublicID,
+		&task.UserID,
+		&task.SolverID,
+		&task.CaptchaType,
+		&task.SiteKey,
+		&task.TargetURL,
+		&task.CaptchaResponse,
+		&task.Status,
+		&task.ErrorMessage,
+		&task.Attempts,
+		&task.CreatedAt,
+		&task.UpdatedAt,
+		&task.SolvedAt,
+		&task.LeaseExpiresAt,
+		&task.CallbackURL,
+		&task.Price,
+		&task.Payout,
+		&task.DeadlineAt,
+		&task.ExpiresAt,
+		&task.Stale,
+		&task.Priority,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// Create сохраняет новую задачу в статусе pending и заполняет её ID и срок
+// ожидания (task.MaxWait или значение по умолчанию для типа капчи)
+func Create(task *models.CaptchaTask) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ev, err := insert(tx, task)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	task.Status = models.StatusPending
+	publish(ev)
+	return nil
+}
+
+// CreateBatch сохраняет несколько задач в одной транзакции. Каждая задача
+// вставляется в своей точке сохранения, поэтому ошибка одной из них
+// (например, нехватка средств на удержание) не отменяет остальные: errs[i] —
+// ошибка задачи batch[i]. Ошибка err означает, что не создана ни одна задача.
+func CreateBatch(batch []*models.CaptchaTask) (errs []error, err error) {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	errs = make([]error, len(batch))
+	events := make([]*Event, len(batch))
+	for i, task := range batch {
+		if _, err := tx.Exec("SAVEPOINT batch_task"); err != nil {
+			return nil, err
+		}
+		ev, err := insert(tx, task)
+		if err != nil {
+			errs[i] = err
+			if _, err := tx.Exec("ROLLBACK TO batch_task"); err != nil {
+				return nil, err
+			}
+		} else {
+			events[i] = &ev
+		}
+		if _, err := tx.Exec("RELEASE batch_task"); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for i, ev := range events {
+		if ev != nil {
+			batch[i].Status = models.StatusPending
+			publish(*ev)
+		}
+	}
+	return errs, nil
+}
+
+// insert добавляет задачу в транзакции tx и выполняет хуки SubscribeTx;
+// событие для подписчиков публикует вызывающий после фиксации
+func insert(tx *sql.Tx, task *models.CaptchaTask) (Event, error) {
+	publicID, err := utils.GeneratePublicID()
+	if err != nil {
+		return Event{}, err
+	}
+
+	// Тип сравнивается с нормализованными типами исполнителей (NormalizeTypes)
+	task.CaptchaType = NormalizeType(task.CaptchaType)
+
+	maxWait := task.MaxWait
+	if maxWait <= 0 {
+		maxWait = MaxWaitFor(task.CaptchaType)
+	}
+
+	var taskID int64
+	err = tx.QueryRow(`INSERT INTO tasks (public_id, user_id, captcha_type, sitekey, target_url, callback_url, price, payout, priority, status, deadline_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, datetime('now', ?)) RETURNING id, deadline_at`,
+		publicID, task.UserID, task.CaptchaType, task.SiteKey, task.TargetURL, task.CallbackURL, task.Price, task.Payout, task.Priority, models.StatusPending,
+		fmt.Sprintf("+%d seconds", int(maxWait.Seconds()))).
+		Scan(&taskID, &task.DeadlineAt)
+	if err != nil {
+		return Event{}, err
+	}
+
+	ev := Event{TaskID: taskID, UserID: task.UserID, To: models.StatusPending}
+	if err := runTxHooks(tx, ev); err != nil {
+		return Event{}, err
+	}
+	task.ID = taskID
+	task.PublicID = publicID
+	return ev, nil
+}
+
+// Get загружает задачу со всеми полями жизненного цикла
+func Get(taskID int64) (*models.CaptchaTask, error) {
+	task, err := scanTask(config.DB.QueryRow("SELECT "+columns+" FROM tasks WHERE id = ?", taskID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	return task, err
+}
+
+// Resolve находит задачу по публичному идентификатору. Числовые ID не
+// принимаются: они последовательны, и по ним легко перебрать чужие задачи.
+func Resolve(ref string) (*models.CaptchaTask, error) {
+	task, err := scanTask(config.DB.QueryRow("SELECT "+columns+" FROM tasks WHERE public_id = ?", ref))
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	return task, err
+}
+
+// CanView проверяет, может ли пользователь видеть задачу: администратор видит
+// все задачи, клиент — свои, исполнитель — арендованные им
+func CanView(task *models.CaptchaTask, user *models.User) bool {
+	switch user.Role {
+	case "admin":
+		return true
+	case "client":
+		return task.UserID == user.ID
+	case "worker":
+		return task.Status == models.StatusAssigned && task.SolverID != nil && *task.SolverID == user.ID
+	default:
+		return false
+	}
+}
+
+// Assign назначает ожидающую задачу исполнителю (pending → assigned) и
+// выдаёт ему аренду на config.LeaseDuration. Фильтры и очерёдность выдачи
+// здесь не проверяются: исполнителям задачи выдаёт только Claim.
+func Assign(taskID, solverID int64) error {
+	return transition(taskID, models.StatusAssigned, nil,
+		"solver_id = ?, assigned_at = datetime('now'), lease_expires_at = datetime('now', ?)", solverID, leaseModifier())
+}
+
+// Release возвращает назначенную задачу в очередь (assigned → pending)
+// без учёта попытки, например если задачу не удалось доставить исполнителю
+func Release(taskID, solverID int64) error {
+	return transition(taskID, models.StatusPending, &solverID, "solver_id = NULL, lease_expires_at = NULL")
+}
+
+// Solve сохраняет решение исполнителя, которому назначена задача (assigned → solved),
+// вместе со временем решения и сроком действия токена (TokenValidityFor)
+func Solve(taskID, solverID int64, response string) error {
+	var captchaType string
+	err := config.DB.QueryRow("SELECT captcha_type FROM tasks WHERE id = ?", taskID).Scan(&captchaType)
+	if err == sql.ErrNoRows {
+		return ErrTaskNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return transition(taskID, models.StatusSolved, &solverID,
+		"captcha_response = ?, error_message = NULL, solved_at = datetime('now'), expires_at = datetime('now', ?), lease_expires_at = NULL",
+		response, fmt.Sprintf("+%d seconds", int(TokenValidityFor(captchaType).Seconds())))
+}
+
+// Fail помечает задачу как нерешённую с указанием причины
+func Fail(taskID int64, reason string) error {
+	return transition(taskID, models.StatusFailed, nil, "error_message = ?, lease_expires_at = NULL", reason)
+}
+
+// Expire помечает задачу как просроченную: она не была решена до deadline_at
+func Expire(taskID int64) error {
+	return transition(taskID, models.StatusExpired, nil, "lease_expires_at = NULL, error_message = ?", "max wait exceeded")
+}
+
+// Cancel отменяет задачу
+func Cancel(taskID int64) error {
+	return transition(taskID, models.StatusCancelled, nil, "lease_expires_at = NULL")
+}
+
+// CancelOwned отменяет задачу по запросу её владельца. Для чужой задачи
+// возвращается ErrTaskNotFound, как и для несуществующей; для завершённой —
+// ErrInvalidTransition.
+func CancelOwned(taskID, userID int64) error {
+	task, err := Get(taskID)
+	if err != nil {
+		return err
+	}
+	if task.UserID != userID {
+		return ErrTaskNotFound
+	}
+	return Cancel(taskID)
+}
+
+// transition — единственное место, где меняется tasks.status. Переход
+// проверяется по таблице transitions, а UPDATE выполняется условно по
+// прочитанному статусу и исполнителю, поэтому параллельное изменение задачи
+// приводит к ErrInvalidTransition, а не к потере данных. set и args
+// дописываются к SET; если solverID задан, задача должна быть назначена ему.
+// Хуки SubscribeTx выполняются в той же транзакции.
+func transition(taskID int64, to string, solverID *int64, set string, args ...interface{}) error {
+	return transitionEvent(Event{TaskID: taskID, To: to}, solverID, set, args...)
+}
+
+// transitionEvent выполняет переход ev.TaskID в ev.To; остальные поля ev
+// (например, Abandoned) передаются подписчикам как есть
+func transitionEvent(ev Event, solverID *int64, set string, args ...interface{}) error {
+	taskID, to := ev.TaskID, ev.To
+
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		status string
+		solver sql.NullInt64
+	)
+	err = tx.QueryRow("SELECT status, solver_id FROM tasks WHERE id = ?", taskID).Scan(&status, &solver)
+	if err == sql.ErrNoRows {
+		return ErrTaskNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if !CanTransition(status, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, status, to)
+	}
+	if solverID != nil && (!solver.Valid || solver.Int64 != *solverID) {
+		return ErrNotAssignee
+	}
+
+	query := "UPDATE tasks SET status = ?"
+	if set != "" {
+		query += ", " + set
+	}
+	query += " WHERE id = ? AND status = ? AND solver_id IS ? RETURNING user_id, solver_id"
+
+	params := append([]interface{}{to}, args...)
+	params = append(params, taskID, status, solver)
+
+	ev.From = status
+	err = tx.QueryRow(query, params...).Scan(&ev.UserID, &ev.SolverID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: task #%d was modified concurrently", ErrInvalidTransition, taskID)
+	}
+	if err != nil {
+		return err
+	}
+	if ev.SolverID == nil && solver.Valid {
+		// Задача снята с исполнителя — сообщаем, у кого её забрали
+		ev.SolverID = &solver.Int64
+	}
+	if err := runTxHooks(tx, ev); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	publish(ev)
+	return nil
+}
@@ -0,0 +1,90 @@
+package tasks
+
+import (
+	"captcha-solver/internal/models"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTask(t *testing.T, userID int64) *models.CaptchaTask {
+	t.Helper()
+	task := &models.CaptchaTask{UserID: userID, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com"}
+	if err := Create(task); err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	return task
+}
+
+func TestResolve(t *testing.T) {
+	setupDB(t)
+	task := newTask(t, 1)
+
+	if !strings.HasPrefix(task.PublicID, "tsk_") {
+		t.Fatalf("unexpected public id %q", task.PublicID)
+	}
+	got, err := Resolve(task.PublicID)
+	if err != nil || got.ID != task.ID {
+		t.Fatalf("Resolve(%q) = %+v, %v", task.PublicID, got, err)
+	}
+	if _, err := Resolve(strconv.FormatInt(task.ID, 10)); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("Resolve of numeric id: got %v, want ErrTaskNotFound", err)
+	}
+	if _, err := Resolve("tsk_unknown"); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("Resolve of unknown id: got %v, want ErrTaskNotFound", err)
+	}
+}
+
+func TestCanView(t *testing.T) {
+	setupDB(t)
+	task := newTask(t, 10)
+
+	owner := &models.User{ID: 10, Role: "client"}
+	other := &models.User{ID: 11, Role: "client"}
+	admin := &models.User{ID: 1, Role: "admin"}
+	worker := &models.User{ID: 20, Role: "worker"}
+
+	if !CanView(task, owner) || CanView(task, other) || !CanView(task, admin) {
+		t.Fatal("client/admin visibility is wrong")
+	}
+	if CanView(task, worker) {
+		t.Fatal("worker sees a task that is not leased to them")
+	}
+
+	if err := Assign(task.ID, worker.ID); err != nil {
+		t.Fatal(err)
+	}
+	task, _ = Get(task.ID)
+	if !CanView(task, worker) {
+		t.Fatal("worker does not see a task leased to them")
+	}
+}
+
+func TestCancelOwned(t *testing.T) {
+	setupDB(t)
+	task := newTask(t, 10)
+	if err := Assign(task.ID, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	var revoked []Event
+	Subscribe(func(ev Event) {
+		if ev.TaskID == task.ID && ev.Revoked() {
+			revoked = append(revoked, ev)
+		}
+	})
+
+	if err := CancelOwned(task.ID, 11); !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("CancelOwned() by another client: err = %v, want ErrTaskNotFound", err)
+	}
+	if err := CancelOwned(task.ID, 10); err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || *revoked[0].SolverID != 20 {
+		t.Fatalf("revoked events = %+v, want one for solver 20", revoked)
+	}
+	if err := CancelOwned(task.ID, 10); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("second CancelOwned(): err = %v, want ErrInvalidTransition", err)
+	}
+}
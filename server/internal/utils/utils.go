@@ -13,3 +13,12 @@ func GenerateAPIKey() (string, error) {
 	}
 	return hex.EncodeToString(bytes), nil
 }
+
+// Генерация публичного идентификатора задачи, который нельзя подобрать перебором
+func GeneratePublicID() (string, error) {
+	key, err := GenerateAPIKey()
+	if err != nil {
+		return "", err
+	}
+	return "tsk_" + key, nil
+}
@@ -0,0 +1,78 @@
+package webhooks
+
+import (
+	"captcha-solver/internal/config"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+var ErrPrivateAddress = errors.New("callback_url must not point to a private or local network address")
+
+// Диапазоны, которые не покрываются методами net.IP: CGNAT и «этот» сетевой 0.0.0.0/8
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("0.0.0.0/8"),
+}
+
+// client отправляет вебхуки. Адрес проверяется при каждом подключении уже
+// после резолва имени, поэтому смена DNS-записи между проверкой callback_url
+// и отправкой (DNS rebinding) не открывает доступ во внутреннюю сеть.
+// Редиректы не выполняются: ответ 3xx считается неуспешной попыткой.
+var client = &http.Client{
+	Timeout: config.WebhookTimeout,
+	Transport: &http.Transport{
+		DialContext:         dialPublic,
+		TLSHandshakeTimeout: config.WebhookTimeout,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func dialPublic(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: config.WebhookTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// isPrivate сообщает, что адрес относится к локальной, внутренней или служебной
+// сети (в том числе 169.254.169.254 — метаданные облака). При
+// config.WebhookAllowPrivateNetworks такие адреса разрешены.
+func isPrivate(ip net.IP) bool {
+	if config.WebhookAllowPrivateNetworks {
+		return false
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,336 @@
+package webhooks
+
+import (
+	"bytes"
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/notify"
+	"captcha-solver/internal/results"
+	"captcha-solver/internal/tasks"
+	"captcha-solver/internal/utils"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Статусы доставки вебхука
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// SignatureHeader содержит HMAC-SHA256 тела запроса, подписанного секретом клиента.
+// Тело включает timestamp (Unix-время отправки) и дублируется в TimestampHeader:
+// получатель отклоняет старые запросы, и перехваченную доставку нельзя повторить.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+)
+
+var ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+// ValidateURL проверяет, что callback_url — абсолютный http(s) адрес вне
+// локальных и внутренних сетей. Имена хостов проверяются при отправке, после
+// резолва (dialPublic).
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("callback_url must be an absolute http(s) URL")
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if ip := net.ParseIP(host); ip != nil && isPrivate(ip) {
+		return ErrPrivateAddress
+	}
+	if !config.WebhookAllowPrivateNetworks && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// Secret возвращает секрет подписи вебхуков клиента, создавая его при первом обращении
+func Secret(userID int64) (string, error) {
+	var secret sql.NullString
+	if err := config.DB.QueryRow("SELECT webhook_secret FROM users WHERE id = ?", userID).Scan(&secret); err != nil {
+		return "", err
+	}
+	if secret.Valid && secret.String != "" {
+		return secret.String, nil
+	}
+
+	generated, err := utils.GenerateAPIKey()
+	if err != nil {
+		return "", err
+	}
+	// Не перезаписываем секрет, если его успели создать параллельно
+	if _, err := config.DB.Exec("UPDATE users SET webhook_secret = ? WHERE id = ? AND (webhook_secret IS NULL OR webhook_secret = '')", generated, userID); err != nil {
+		return "", err
+	}
+	if err := config.DB.QueryRow("SELECT webhook_secret FROM users WHERE id = ?", userID).Scan(&secret); err != nil {
+		return "", err
+	}
+	return secret.String, nil
+}
+
+// Sign возвращает значение заголовка подписи для тела запроса
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// kick будит RunDeliveryWorker сразу после появления новых доставок
+var kick = make(chan struct{}, 1)
+
+// Start подписывает отправку вебхуков на события о результатах задач.
+// Доставка записывается в транзакции обработки события, поэтому не теряется,
+// если сервер упадёт до отправки; сама отправка выполняется RunDeliveryWorker.
+func Start() {
+	results.SubscribeTx(recordDelivery)
+	results.Subscribe(func(results.Event) {
+		select {
+		case kick <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// RunDeliveryWorker отправляет новые вебхуки и периодически повторяет недоставленные
+func RunDeliveryWorker() {
+	ticker := time.NewTicker(config.WebhookRetryBase / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-kick:
+		}
+		deliverDue()
+	}
+}
+
+// Redeliver ставит доставку клиента в очередь на повторную отправку
+func Redeliver(deliveryID, userID int64) error {
+	res, err := config.DB.Exec(`UPDATE webhook_deliveries
+		SET status = ?, attempts = 0, next_attempt_at = datetime('now')
+		WHERE id = ? AND user_id = ?`, DeliveryPending, deliveryID, userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrDeliveryNotFound
+	}
+
+	go attempt(deliveryID)
+	return nil
+}
+
+// ListForUser возвращает последние доставки клиента вместе с итогом последней попытки
+func ListForUser(userID int64, limit int) ([]*models.WebhookDelivery, error) {
+	rows, err := config.DB.Query(`
+		SELECT d.id, d.task_id, d.user_id, d.url, d.event, d.status, d.attempts,
+		       d.next_attempt_at, d.created_at, d.delivered_at, a.status_code, a.error
+		FROM webhook_deliveries d
+		LEFT JOIN webhook_attempts a ON a.id = (
+			SELECT MAX(id) FROM webhook_attempts WHERE delivery_id = d.id
+		)
+		WHERE d.user_id = ?
+		ORDER BY d.id DESC
+		LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*models.WebhookDelivery
+	for rows.Next() {
+		var d models.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.TaskID, &d.UserID, &d.URL, &d.Event, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt, &d.LastStatusCode, &d.LastError); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, nil
+}
+
+// recordDelivery записывает доставку вебхука о событии в транзакции tx,
+// если у задачи задан callback_url
+func recordDelivery(tx *sql.Tx, ev results.Event) error {
+	var callbackURL sql.NullString
+	err := tx.QueryRow("SELECT callback_url FROM tasks WHERE id = ?", ev.TaskID).Scan(&callbackURL)
+	if err == sql.ErrNoRows {
+		// Задачу уже удалили — доставлять некому
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load callback URL of task #%d: %w", ev.TaskID, err)
+	}
+	if !callbackURL.Valid || callbackURL.String == "" {
+		return nil
+	}
+
+	_, err = tx.Exec("INSERT INTO webhook_deliveries (task_id, user_id, url, event) VALUES (?, ?, ?, ?)",
+		ev.TaskID, ev.UserID, callbackURL.String, notify.EventName(ev.Status))
+	if err != nil {
+		return fmt.Errorf("create webhook delivery for task #%d: %w", ev.TaskID, err)
+	}
+	return nil
+}
+
+func deliverDue() {
+	rows, err := config.DB.Query("SELECT id FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= datetime('now')", DeliveryPending)
+	if err != nil {
+		log.Println("Error loading due webhook deliveries:", err)
+		return
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		attempt(id)
+	}
+}
+
+// attempt выполняет одну попытку доставки. Перед отправкой доставка
+// откладывается на время таймаута, поэтому параллельный вызов её пропустит.
+func attempt(deliveryID int64) {
+	res, err := config.DB.Exec(`UPDATE webhook_deliveries SET next_attempt_at = datetime('now', ?)
+		WHERE id = ? AND status = ? AND next_attempt_at <= datetime('now')`,
+		fmt.Sprintf("+%d seconds", int(config.WebhookTimeout.Seconds())+1), deliveryID, DeliveryPending)
+	if err != nil {
+		log.Printf("Error locking webhook delivery #%d: %v", deliveryID, err)
+		return
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return
+	}
+
+	var (
+		taskID, userID int64
+		target, event  string
+		attempts       int
+	)
+	err = config.DB.QueryRow("SELECT task_id, user_id, url, event, attempts FROM webhook_deliveries WHERE id = ?", deliveryID).
+		Scan(&taskID, &userID, &target, &event, &attempts)
+	if err != nil {
+		log.Printf("Error loading webhook delivery #%d: %v", deliveryID, err)
+		return
+	}
+
+	started := time.Now()
+	statusCode, sendErr := send(deliveryID, taskID, userID, target, event)
+	duration := time.Since(started)
+	attempts++
+
+	var errText *string
+	if sendErr != nil {
+		msg := sendErr.Error()
+		errText = &msg
+	}
+	var code *int
+	if statusCode > 0 {
+		code = &statusCode
+	}
+	if _, err := config.DB.Exec("INSERT INTO webhook_attempts (delivery_id, status_code, error, duration_ms) VALUES (?, ?, ?, ?)",
+		deliveryID, code, errText, duration.Milliseconds()); err != nil {
+		log.Printf("Error recording webhook attempt #%d: %v", deliveryID, err)
+	}
+
+	switch {
+	case sendErr == nil:
+		_, err = config.DB.Exec("UPDATE webhook_deliveries SET status = ?, attempts = ?, delivered_at = datetime('now') WHERE id = ?",
+			DeliveryDelivered, attempts, deliveryID)
+		log.Printf("📤 Webhook #%d for task #%d delivered to %s", deliveryID, taskID, target)
+	case attempts >= config.WebhookMaxAttempts:
+		_, err = config.DB.Exec("UPDATE webhook_deliveries SET status = ?, attempts = ? WHERE id = ?",
+			DeliveryFailed, attempts, deliveryID)
+		log.Printf("❌ Webhook #%d for task #%d failed after %d attempts: %v", deliveryID, taskID, attempts, sendErr)
+	default:
+		_, err = config.DB.Exec("UPDATE webhook_deliveries SET attempts = ?, next_attempt_at = datetime('now', ?) WHERE id = ?",
+			attempts, fmt.Sprintf("+%d seconds", int(backoff(attempts).Seconds())), deliveryID)
+		log.Printf("⚠️ Webhook #%d for task #%d failed (attempt %d): %v", deliveryID, taskID, attempts, sendErr)
+	}
+	if err != nil {
+		log.Printf("Error updating webhook delivery #%d: %v", deliveryID, err)
+	}
+}
+
+// backoff возвращает задержку перед следующей попыткой: base·2^(n-1), но не больше часа
+func backoff(attempts int) time.Duration {
+	delay := config.WebhookRetryBase
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// send отправляет подписанный POST с текущим состоянием задачи
+func send(deliveryID, taskID, userID int64, target, event string) (int, error) {
+	task, err := tasks.Get(taskID)
+	if err != nil {
+		return 0, err
+	}
+	secret, err := Secret(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	sentAt := time.Now().Unix()
+	payload := notify.Message(task)
+	payload["event"] = event
+	payload["timestamp"] = sentAt
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "captcha-solver-webhook")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(deliveryID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(sentAt, 10))
+	req.Header.Set(SignatureHeader, Sign(secret, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,188 @@
+package webhooks
+
+import (
+	"captcha-solver/internal/config"
+	"captcha-solver/internal/db"
+	"captcha-solver/internal/models"
+	"captcha-solver/internal/results"
+	"captcha-solver/internal/tasks"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupDB(t *testing.T) {
+	t.Helper()
+	config.DBPath = filepath.Join(t.TempDir(), "test.db")
+	db.DB_Connect()
+	t.Cleanup(func() { config.DB.Close() })
+
+	// Тестовые серверы слушают 127.0.0.1
+	config.WebhookAllowPrivateNetworks = true
+	t.Cleanup(func() { config.WebhookAllowPrivateNetworks = false })
+}
+
+// enqueue записывает доставку так же, как обработчик события о результате,
+// и сразу выполняет её
+func enqueue(t *testing.T, ev results.Event) {
+	t.Helper()
+	tx, err := config.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := recordDelivery(tx, ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	deliverDue()
+}
+
+func solvedTaskWithCallback(t *testing.T, callbackURL string) *models.CaptchaTask {
+	t.Helper()
+	task := &models.CaptchaTask{UserID: 1, CaptchaType: "hcaptcha", SiteKey: "sitekey", TargetURL: "https://example.com", CallbackURL: &callbackURL}
+	if err := tasks.Create(task); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Assign(task.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tasks.Solve(task.ID, 1, "token"); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestDeliverySigned(t *testing.T) {
+	setupDB(t)
+
+	var gotSignature, gotTimestamp string
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature = r.Header.Get(SignatureHeader)
+		gotTimestamp = r.Header.Get(TimestampHeader)
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	task := solvedTaskWithCallback(t, srv.URL)
+	enqueue(t, results.Event{TaskID: task.ID, UserID: task.UserID, From: models.StatusAssigned, Status: models.StatusSolved})
+
+	secret, err := Secret(task.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := Sign(secret, gotBody); gotSignature != want {
+		t.Fatalf("signature %q, want %q", gotSignature, want)
+	}
+	// Время отправки входит в подписанное тело
+	var payload struct {
+		Timestamp int64 `json:"timestamp"`
+	}
+	if err := json.Unmarshal(gotBody, &payload); err != nil || payload.Timestamp == 0 || strconv.FormatInt(payload.Timestamp, 10) != gotTimestamp {
+		t.Fatalf("signed timestamp %d, header %q, err %v; want matching values", payload.Timestamp, gotTimestamp, err)
+	}
+
+	deliveries, err := ListForUser(task.UserID, 10)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("deliveries: %v %v", deliveries, err)
+	}
+	if d := deliveries[0]; d.Status != DeliveryDelivered || d.Event != "task_solved" || d.Attempts != 1 {
+		t.Fatalf("unexpected delivery %+v", d)
+	}
+}
+
+func TestDeliveryRetriedOnError(t *testing.T) {
+	setupDB(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	task := solvedTaskWithCallback(t, srv.URL)
+	enqueue(t, results.Event{TaskID: task.ID, UserID: task.UserID, From: models.StatusAssigned, Status: models.StatusSolved})
+
+	deliveries, _ := ListForUser(task.UserID, 10)
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Status != DeliveryPending || d.Attempts != 1 || d.LastStatusCode == nil || *d.LastStatusCode != http.StatusBadGateway {
+		t.Fatalf("unexpected delivery %+v", d)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base := config.WebhookRetryBase
+	if got := backoff(1); got != base {
+		t.Fatalf("backoff(1) = %s, want %s", got, base)
+	}
+	if got := backoff(3); got != 4*base {
+		t.Fatalf("backoff(3) = %s, want %s", got, 4*base)
+	}
+	if got := backoff(100); got > time.Hour {
+		t.Fatalf("backoff(100) = %s, want at most 1h", got)
+	}
+}
+
+func TestValidateURLRejectsPrivateAddresses(t *testing.T) {
+	for _, raw := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/hook",
+		"http://100.64.0.1/hook",
+	} {
+		if err := ValidateURL(raw); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("ValidateURL(%q) = %v, want ErrPrivateAddress", raw, err)
+		}
+	}
+	if err := ValidateURL("https://example.com/hook"); err != nil {
+		t.Fatalf("ValidateURL(public) = %v", err)
+	}
+}
+
+func TestDeliveryRefusesPrivateAddressAndRedirects(t *testing.T) {
+	setupDB(t)
+
+	var internalHits int
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalHits++
+	}))
+	defer internal.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+	defer redirect.Close()
+
+	// Редирект не выполняется
+	task := solvedTaskWithCallback(t, redirect.URL)
+	enqueue(t, results.Event{TaskID: task.ID, UserID: task.UserID, From: models.StatusAssigned, Status: models.StatusSolved})
+	deliveries, _ := ListForUser(task.UserID, 10)
+	if len(deliveries) != 1 || deliveries[0].LastStatusCode == nil || *deliveries[0].LastStatusCode != http.StatusFound {
+		t.Fatalf("deliveries = %+v, want one failed with 302", deliveries)
+	}
+
+	// Адрес, который резолвится во внутреннюю сеть, отклоняется при подключении
+	config.WebhookAllowPrivateNetworks = false
+	task = solvedTaskWithCallback(t, internal.URL)
+	enqueue(t, results.Event{TaskID: task.ID, UserID: task.UserID, From: models.StatusAssigned, Status: models.StatusSolved})
+	deliveries, _ = ListForUser(task.UserID, 10)
+	if len(deliveries) != 2 || deliveries[0].Status != DeliveryPending || deliveries[0].LastError == nil ||
+		!strings.Contains(*deliveries[0].LastError, ErrPrivateAddress.Error()) {
+		t.Fatalf("deliveries = %+v, want the newest refused as a private address", deliveries)
+	}
+	if internalHits != 0 {
+		t.Fatalf("internal server got %d requests, want none", internalHits)
+	}
+}
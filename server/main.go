@@ -1,11 +1,19 @@
 package main
 
 import (
+	"captcha-solver/internal/billing"
+	"captcha-solver/internal/broker"
 	"captcha-solver/internal/config"
 	"captcha-solver/internal/data"
 	"captcha-solver/internal/db"
+	"captcha-solver/internal/dispatch"
+	"captcha-solver/internal/notify"
 	"captcha-solver/internal/rabbitmq"
+	"captcha-solver/internal/reputation"
+	"captcha-solver/internal/results"
 	"captcha-solver/internal/routes"
+	"captcha-solver/internal/tasks"
+	"captcha-solver/internal/webhooks"
 	"log"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,18 +21,60 @@ import (
 )
 
 func main() {
-	// Connect to RabbitMQ
-	rabbitmq.RabbitMQConnect()
-
-	defer rabbitmq.RabbitMQConn.Close()
-	defer rabbitmq.RabbitMQChannel.Close()
+	// Connect to the message broker: RabbitMQ connects in the background and
+	// reconnects after broker restarts; BROKER=memory runs without RabbitMQ
+	if config.BrokerKind == "memory" {
+		broker.Init(broker.NewMemory())
+	} else {
+		broker.Init(rabbitmq.New(config.RabbitMQURL))
+	}
+	defer broker.Default().Close()
 
 	// Open or create the SQLite database
 	db.DB_Connect()
 	defer config.DB.Close()
 
-	// Start RabbitMQ consumer in a goroutine
-	go rabbitmq.ConsumeTasks()
+	// Reconcile balances with the ledger and bill task lifecycle changes
+	if err := billing.Reconcile(); err != nil {
+		log.Fatalf("Error reconciling balances: %v", err)
+	}
+	billing.Start()
+
+	// Track worker reputation and keep low-rated workers away from tasks
+	if err := reputation.Backfill(); err != nil {
+		log.Fatalf("Error backfilling worker reputation: %v", err)
+	}
+	reputation.Start()
+
+	// Start the task queue consumer in a goroutine
+	go broker.ConsumeTasks()
+
+	// Republish tasks that could not be queued when they were created
+	go broker.RunTaskRepublisher()
+
+	// Return tasks with expired leases to the queue
+	go tasks.RunLeaseReaper()
+
+	// Expire tasks that were not solved within their max wait
+	go tasks.RunExpirer()
+
+	// Forget idempotency keys older than their retention
+	go tasks.RunIdempotencyPurger()
+
+	// Push new tasks to idle WebSocket workers
+	dispatch.Start()
+
+	// Notify task owners over WebSocket when their tasks finish
+	notify.Start()
+
+	// Deliver and retry signed webhook callbacks
+	webhooks.Start()
+	go webhooks.RunDeliveryWorker()
+
+	// Publish task results to the captcha_results exchange and fan them out
+	// to the subscribers above once they come back from the server's queue
+	results.Start()
+	go results.RunPublisher()
 
 	// Initialize HTML template engine (templates in folder views)
 	engine := html.New("./views", ".html")
@@ -3,6 +3,7 @@ use async_tungstenite::tokio::connect_async;
 use futures_util::{SinkExt, StreamExt};
 use serde::{Deserialize, Serialize};
 use serde_json::json;
+use std::collections::VecDeque;
 use std::env;
 use std::fs::File;
 use std::io::Write;
@@ -22,6 +23,9 @@ struct AuthPayload {
     api_key: String,
     #[serde(default)]
     command: Option<String>,
+    // Типы капчи, которые умеет решать клиент; сервер выдаёт только их
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    captcha_types: Option<Vec<String>>,
 }
 
 #[derive(Serialize, Deserialize, Debug)]
@@ -119,6 +123,7 @@ async fn run_task_mode() {
     };
 
     let api_key = auth.api_key;
+    let captcha_types = auth.captcha_types;
 
     eprintln!("🌐 Подключение к WebSocket...");
     let (ws_stream, _) = match connect_async("ws://127.0.0.1:8080/socket").await {
@@ -132,7 +137,7 @@ async fn run_task_mode() {
     let (mut write, mut read) = ws_stream.split();
 
     // Отправка API ключа
-    let Ok(auth_json) = serde_json::to_string(&AuthPayload { api_key: api_key.clone(), command: None }) else {
+    let Ok(auth_json) = serde_json::to_string(&AuthPayload { api_key: api_key.clone(), command: None, captcha_types }) else {
         eprintln!("❌ Ошибка сериализации ключа");
         return;
     };
@@ -170,164 +175,113 @@ async fn run_task_mode() {
 
     eprintln!("✅ Авторизация успешна");
 
-    loop {
-        let Ok(Some(line)) = lines.next_line().await else {
-            eprintln!("🔚 Stdin закрыт");
-            break;
-        };
-
-        if line.trim().is_empty() {
-            continue;
-        }
+    // Ответы на команды приходят в порядке отправки, а события сервера (с полем
+    // "event", например task_revoked) — в любой момент. Поэтому stdin и сокет
+    // читаются одновременно, а в pending хранятся команды, ждущие ответа.
+    let mut pending: VecDeque<String> = VecDeque::new();
 
-        let parsed: serde_json::Value = match serde_json::from_str(&line) {
-            Ok(val) => val,
-            Err(e) => {
-                eprintln!("⚠️ Невалидный JSON: {} ({})", line, e);
-                continue;
-            }
-        };
-
-        if parsed.get("command") == Some(&json!("get_task")) {
-            let cmd = json!({ "command": "get_task" });
-            if let Err(e) = write.send(cmd.to_string().into()).await {
-                eprintln!("❌ Ошибка запроса задачи: {e}");
-                break;
-            }
+    loop {
+        tokio::select! {
+            line = lines.next_line() => {
+                let Ok(Some(line)) = line else {
+                    eprintln!("🔚 Stdin закрыт");
+                    break;
+                };
+
+                if line.trim().is_empty() {
+                    continue;
+                }
 
-            // ждем ответ
-            match read.next().await {
-                Some(Ok(msg)) if msg.is_text() => {
-                    let text = msg.to_text().unwrap_or("");
-                    if !text.trim().is_empty() {
-                        match serde_json::from_str::<serde_json::Value>(text) {
-                            Ok(val) => {
-                                if val.get("status") == Some(&json!("no_tasks")) {
-                                    eprintln!("ℹ️ Немає задач у черзі");
-                                } else {
-                                    println!("{}", text);
-                                }
-                            }
-                            Err(e) => {
-                                eprintln!("❌ Помилка парсингу JSON: {e}, дані: {text}");
-                            }
-                        }
+                let parsed: serde_json::Value = match serde_json::from_str(&line) {
+                    Ok(val) => val,
+                    Err(e) => {
+                        eprintln!("⚠️ Невалидный JSON: {} ({})", line, e);
+                        continue;
                     }
-                },
-                _ => {
-                    eprintln!("❌ Не получен ответ на запрос задачи");
-                }
-            }
-        } else if parsed.get("command") == Some(&json!("create_task")) {
-            let cmd = json!({
-                "command": "create_task",
-                "sitekey": parsed.get("sitekey").unwrap_or(&json!("")),
-                "target_url": parsed.get("target_url").unwrap_or(&json!("")),
-                "captcha_type": parsed.get("captcha_type").unwrap_or(&json!("hcaptcha"))
-            });
-            if let Err(e) = write.send(cmd.to_string().into()).await {
-                eprintln!("❌ Ошибка создания задачи: {e}");
-                break;
-            }
+                };
 
-            // ждем ответ
-            match read.next().await {
-                Some(Ok(msg)) if msg.is_text() => {
-                    println!("{}", msg.to_text().unwrap_or(""));
-                },
-                _ => {
-                    eprintln!("❌ Не получен ответ на создание задачи");
-                }
-            }
-        } else if parsed.get("command") == Some(&json!("get_tasks")) {
-            let cmd = json!({ "command": "get_tasks" });
-            if let Err(e) = write.send(cmd.to_string().into()).await {
-                eprintln!("❌ Ошибка запроса задач: {e}");
-                break;
-            }
+                let Some((name, cmd)) = build_command(&parsed) else {
+                    eprintln!("⚠️ Неизвестная команда: {}", parsed.get("command").unwrap_or(&json!("unknown")));
+                    continue;
+                };
 
-            // ждем ответ
-            match read.next().await {
-                Some(Ok(msg)) if msg.is_text() => {
-                    println!("{}", msg.to_text().unwrap_or(""));
-                },
-                _ => {
-                    eprintln!("❌ Не получен ответ на запрос задач");
+                if let Err(e) = write.send(cmd.to_string().into()).await {
+                    eprintln!("❌ Ошибка отправки команды {name}: {e}");
+                    break;
                 }
+                pending.push_back(name);
             }
-        } else if parsed.get("command") == Some(&json!("get_task")) {
-            let cmd = json!({
-                "command": "get_task",
-                "task_id": parsed.get("task_id").unwrap_or(&json!(0))
-            });
-            if let Err(e) = write.send(cmd.to_string().into()).await {
-                eprintln!("❌ Ошибка запроса задачи: {e}");
-                break;
-            }
-
-            // ждем ответ
-            match read.next().await {
-                Some(Ok(msg)) if msg.is_text() => {
-                    let text = msg.to_text().unwrap_or("");
-                    if !text.trim().is_empty() {
-                        match serde_json::from_str::<serde_json::Value>(text) {
-                            Ok(val) => {
-                                if val.get("status") == Some(&json!("no_tasks")) {
-                                    eprintln!("ℹ️ Немає задач у черзі");
-                                } else {
-                                    println!("{}", text);
-                                }
-                            }
-                            Err(e) => {
-                                eprintln!("❌ Помилка парсингу JSON: {e}, дані: {text}");
-                            }
-                        }
+            frame = read.next() => {
+                let text = match frame {
+                    Some(Ok(msg)) if msg.is_text() => msg.to_text().unwrap_or("").to_string(),
+                    Some(Ok(_)) => continue,
+                    Some(Err(e)) => {
+                        eprintln!("❌ Ошибка чтения из WebSocket: {e}");
+                        break;
                     }
-                },
-                _ => {
-                    eprintln!("❌ Не получен ответ на запрос задачи");
-                }
-            }
-        } else if parsed.get("command") == Some(&json!("submit_solution")) {
-            let cmd = json!({
-                "command": "submit_solution",
-                "task_id": parsed.get("task_id").unwrap_or(&json!(0)),
-                "solution": parsed.get("solution").unwrap_or(&json!(""))
-            });
-            if let Err(e) = write.send(cmd.to_string().into()).await {
-                eprintln!("❌ Ошибка отправки решения: {e}");
-                break;
+                    None => {
+                        eprintln!("🔌 Сервер закрыл соединение");
+                        break;
+                    }
+                };
+                handle_frame(&text, &mut pending);
             }
+        }
+    }
 
-            // ждем ответ
-            match read.next().await {
-                Some(Ok(msg)) if msg.is_text() => {
-                    println!("{}", msg.to_text().unwrap_or(""));
-                },
-                _ => {
-                    eprintln!("❌ Не получен ответ на отправку решения");
-                }
-            }
-        } else if parsed.get("command") == Some(&json!("get_queue_count")) {
-            let cmd = json!({ "command": "get_queue_count" });
-            if let Err(e) = write.send(cmd.to_string().into()).await {
-                eprintln!("❌ Ошибка запроса количества задач: {e}");
-                break;
-            }
+    eprintln!("👋 Завершение CLI");
+}
 
-            // ждем ответ
-            match read.next().await {
-                Some(Ok(msg)) if msg.is_text() => {
-                    println!("{}", msg.to_text().unwrap_or(""));
-                },
-                _ => {
-                    eprintln!("❌ Не получен ответ на запрос количества задач");
-                }
-            }
-        } else {
-            eprintln!("⚠️ Неизвестная команда: {}", parsed.get("command").unwrap_or(&json!("unknown")));
+// build_command формирует команду для сервера из строки stdin и возвращает её
+// имя, чтобы сопоставить с ней ответ
+fn build_command(parsed: &serde_json::Value) -> Option<(String, serde_json::Value)> {
+    let name = parsed.get("command")?.as_str()?.to_string();
+    let cmd = match name.as_str() {
+        "get_task" => json!({ "command": "get_task" }),
+        "create_task" => json!({
+            "command": "create_task",
+            "sitekey": parsed.get("sitekey").unwrap_or(&json!("")),
+            "target_url": parsed.get("target_url").unwrap_or(&json!("")),
+            "captcha_type": parsed.get("captcha_type").unwrap_or(&json!("hcaptcha"))
+        }),
+        "get_tasks" => json!({ "command": "get_tasks" }),
+        "submit_solution" => json!({
+            "command": "submit_solution",
+            "task_id": parsed.get("task_id").unwrap_or(&json!(0)),
+            "solution": parsed.get("solution").unwrap_or(&json!(""))
+        }),
+        "get_queue_count" => json!({ "command": "get_queue_count" }),
+        _ => return None,
+    };
+    Some((name, cmd))
+}
+
+// handle_frame передаёт в stdout событие сервера или ответ на самую раннюю
+// из ожидающих команд
+fn handle_frame(text: &str, pending: &mut VecDeque<String>) {
+    if text.trim().is_empty() {
+        return;
+    }
+
+    let val: serde_json::Value = match serde_json::from_str(text) {
+        Ok(val) => val,
+        Err(e) => {
+            eprintln!("❌ Помилка парсингу JSON: {e}, дані: {text}");
+            return;
         }
+    };
+
+    // Событие не является ответом ни на одну команду
+    if val.get("event").is_some() {
+        println!("{}", text);
+        return;
     }
 
-    eprintln!("👋 Завершение CLI");
-}
\ No newline at end of file
+    match pending.pop_front().as_deref() {
+        Some("get_task") if val.get("status") == Some(&json!("no_tasks")) => {
+            eprintln!("ℹ️ Немає задач у черзі");
+        }
+        Some(_) => println!("{}", text),
+        None => eprintln!("⚠️ Сообщение сервера без запроса: {text}"),
+    }
+}
@@ -4,9 +4,14 @@ const { spawn } = require('child_process');
 
 let mainWin;
 let captchaWin = null;
+let captchaTaskId = null;
 let rustProcess = null;
 let rustStdin = null;
 
+// Типы капчи, которые умеет решать этот клиент (внедряется только reCAPTCHA);
+// сервер не будет выдавать задачи других типов
+const SUPPORTED_CAPTCHA_TYPES = ['recaptcha'];
+
 function getRustPath() {
   return process.platform === 'win32'
     ? path.join(process.resourcesPath, 'captcha_cli.exe')
@@ -193,24 +198,24 @@ function startRustSolver() {
     rustProcess = spawn(rustPath);
     rustStdin = rustProcess.stdin;
 
-    rustStdin.write(JSON.stringify({ api_key: global.apiKey }) + '\n');
+    rustStdin.write(JSON.stringify({ api_key: global.apiKey, captcha_types: SUPPORTED_CAPTCHA_TYPES }) + '\n');
 
     setTimeout(() => requestNewTask(), 1000);
 
+    // Rust печатает по одному JSON на строку; в одном чанке их может быть несколько
+    let stdoutBuffer = '';
     rustProcess.stdout.on('data', (data) => {
-      try {
-        const task = JSON.parse(data.toString().trim());
-        console.log("📦 Got task:", task);
+      stdoutBuffer += data.toString();
+      const lines = stdoutBuffer.split('\n');
+      stdoutBuffer = lines.pop();
 
-        if (!task.url || !task.sitekey) {
-          console.log("ℹ️ No task or incomplete task:", task);
-          return;
+      for (const line of lines) {
+        if (!line.trim()) continue;
+        try {
+          handleRustMessage(JSON.parse(line));
+        } catch (e) {
+          console.error("❌ Rust parsing error:", e);
         }
-
-        openCaptchaWindow(task);
-
-      } catch (e) {
-        console.error("❌ Rust parsing error:", e);
       }
     });
 
@@ -236,7 +241,33 @@ function startRustSolver() {
   }
 }
 
+function handleRustMessage(message) {
+  // Сервер отозвал задачу (истекла аренда, задачу отменили) — решать её уже незачем
+  if (message.event === 'task_revoked') {
+    console.log("🚫 Task revoked:", message.task_id, message.status);
+    if (captchaWin && captchaTaskId === message.task_id) {
+      captchaWin.close();
+      captchaWin = null;
+      requestNewTask();
+    }
+    return;
+  }
+  if (message.event) {
+    return;
+  }
+
+  console.log("📦 Got task:", message);
+  if (!message.url || !message.sitekey) {
+    console.log("ℹ️ No task or incomplete task:", message);
+    return;
+  }
+
+  openCaptchaWindow(message);
+}
+
 function openCaptchaWindow(task) {
+  captchaTaskId = task.task_id;
+
   captchaWin = new BrowserWindow({
     width: 1000,
     height: 800,
@@ -258,6 +289,7 @@ function openCaptchaWindow(task) {
 
   captchaWin.on('closed', () => {
     captchaWin = null;
+    captchaTaskId = null;
   });
 }
 